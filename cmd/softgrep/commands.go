@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/skrider/softgrep/pkg/config"
+	"github.com/skrider/softgrep/pkg/embed"
+	"github.com/skrider/softgrep/pkg/output"
+	"github.com/skrider/softgrep/pkg/softgrep"
+)
+
+// runCommand runs one of the subcommands that work with the persistent
+// index.
+func runCommand(ctx context.Context, config *config.Config, command string, args []string) error {
+	switch command {
+	case "index":
+		return runIndex(ctx, config, args)
+	case "search":
+		if len(args) != 1 {
+			printUsage()
+		}
+		return runSearch(ctx, config, args[0])
+	case "status":
+		return runStatus(config)
+	case "gc":
+		return runGC(config)
+	default:
+		return fmt.Errorf("unknown command %q", command)
+	}
+}
+
+func runIndex(ctx context.Context, config *config.Config, paths []string) error {
+	if m, err := softgrep.LoadManifest(config.IndexDir); err == nil {
+		// the index is rebuilt from scratch, so keep the paths it was built
+		// from alongside any new ones
+		paths = mergePaths(paths, manifestPaths(m, len(paths) > 0))
+	}
+	paths, err := defaultPaths(paths)
+	if err != nil {
+		return err
+	}
+
+	ix, err := softgrep.NewIndexer(softgrep.Options{Config: config})
+	if err != nil {
+		return err
+	}
+	defer ix.Close()
+	if err := ix.Check(ctx); err != nil {
+		return serverError(config, err)
+	}
+	if err := ix.Index(ctx, paths); err != nil {
+		return err
+	}
+	if err := ix.Save(config.IndexDir); err != nil {
+		return err
+	}
+
+	m, err := softgrep.LoadManifest(config.IndexDir)
+	if err != nil {
+		return err
+	}
+	log.Printf("Indexed %d chunks from %d files into %s", m.Chunks(), len(m.Files), config.IndexDir)
+	return nil
+}
+
+// manifestPaths returns the paths the index of m was built from, relative to
+// the directory it was built in. Standard input cannot be read again, so it
+// is left out when skipStdin is set.
+func manifestPaths(m *softgrep.Manifest, skipStdin bool) []string {
+	// paths stay relative when indexing from the same directory again
+	cwd, _ := os.Getwd()
+	var paths []string
+	for _, path := range m.Paths {
+		if path == "-" {
+			if !skipStdin {
+				paths = append(paths, path)
+			}
+			continue
+		}
+		if !filepath.IsAbs(path) && m.Root != cwd {
+			path = filepath.Join(m.Root, path)
+		}
+		paths = append(paths, path)
+	}
+	return paths
+}
+
+// mergePaths appends the paths of extra that do not name one of paths.
+func mergePaths(paths []string, extra []string) []string {
+	seen := make(map[string]bool, len(paths))
+	for _, path := range paths {
+		if abs, err := filepath.Abs(path); err == nil {
+			seen[abs] = true
+		}
+	}
+	for _, path := range extra {
+		abs, err := filepath.Abs(path)
+		if err == nil && seen[abs] {
+			continue
+		}
+		seen[abs] = true
+		paths = append(paths, path)
+	}
+	return paths
+}
+
+func runSearch(ctx context.Context, config *config.Config, query string) error {
+	printer, err := output.New(os.Stdout, config)
+	if err != nil {
+		return err
+	}
+	s, _, err := softgrep.OpenSearcher(softgrep.Options{Config: config}, config.IndexDir)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("no index in %s, build one with softgrep index", config.IndexDir)
+	} else if err != nil {
+		return err
+	}
+	defer s.Close()
+	if err := s.Check(ctx); err != nil {
+		return serverError(config, err)
+	}
+	results, err := s.Search(ctx, query, config.TopK)
+	if err != nil {
+		return err
+	}
+	return printResults(printer, results)
+}
+
+func runStatus(config *config.Config) error {
+	m, err := softgrep.LoadManifest(config.IndexDir)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("no index in %s, build one with softgrep index", config.IndexDir)
+	} else if err != nil {
+		return err
+	}
+	stale, err := m.Stale()
+	if err != nil {
+		return err
+	}
+	model, version, err := embed.ModelID(config)
+	if err != nil {
+		return err
+	}
+	if config.Backend == embed.BACKEND_TRITON && version == "" {
+		// the index was built with the latest version at the time
+		version = m.ModelVersion
+	}
+
+	fmt.Printf("index:     %s\n", config.IndexDir)
+	if m.LexicalOnly {
+		fmt.Println("model:     none, lexical search only")
+	} else {
+		fmt.Printf("model:     %s (version %q)\n", m.Model, m.ModelVersion)
+		fmt.Printf("tokenizer: %s\n", m.Tokenizer)
+		fmt.Printf("type:      %s, quantization %s\n", m.IndexType, m.Quantize)
+	}
+	fmt.Printf("files:     %d\n", len(m.Files))
+	fmt.Printf("chunks:    %d\n", m.Chunks())
+	fmt.Printf("built:     %s (%s ago)\n", m.Created.Format(time.RFC3339), time.Since(m.Created).Round(time.Second))
+	if !m.LexicalOnly && (m.Model != model || m.ModelVersion != version) {
+		fmt.Printf("warning:   configured model is %s (version %q), rebuild with softgrep index\n", model, version)
+	}
+	if !stale.Stale() {
+		fmt.Println("status:    up to date")
+		return nil
+	}
+	fmt.Printf("status:    stale, %d modified, %d deleted, %d added\n", len(stale.Modified), len(stale.Deleted), len(stale.Added))
+	for _, l := range []struct {
+		name  string
+		paths []string
+	}{
+		{"modified", stale.Modified},
+		{"deleted", stale.Deleted},
+		{"added", stale.Added},
+	} {
+		for _, path := range l.paths {
+			fmt.Printf("    %s: %s\n", l.name, path)
+		}
+	}
+	return nil
+}
+
+func runGC(config *config.Config) error {
+	if config.NoCache {
+		return nil
+	}
+	deleted, err := softgrep.GC(softgrep.Options{Config: config})
+	if err != nil {
+		return err
+	}
+	log.Printf("Deleted %d cache entries from %s", deleted, config.CacheDir)
+	return nil
+}
+
+func showConfig(config *config.Config, sources []string) error {
+	if len(sources) == 0 {
+		fmt.Println("# defaults only")
+	}
+	for _, source := range sources {
+		fmt.Printf("# from %s\n", source)
+	}
+	// never print credentials
+	shown := *config
+	for _, secret := range []*string{&shown.Token, &shown.APIKey} {
+		if *secret != "" {
+			*secret = "<redacted>"
+		}
+	}
+	return shown.Write(os.Stdout)
+}
@@ -1,17 +1,20 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
-	"io"
+	"fmt"
 	"log"
 	"os"
-	"runtime"
-	"sync"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
 
-	"github.com/skrider/softgrep/pkg/chunk"
 	"github.com/skrider/softgrep/pkg/config"
-	"github.com/skrider/softgrep/pkg/tokenize"
-	"github.com/skrider/softgrep/pkg/walker"
+	"github.com/skrider/softgrep/pkg/output"
+	"github.com/skrider/softgrep/pkg/softgrep"
 )
 
 const USAGE string = `softgrep 0.0.1
@@ -32,34 +35,143 @@ of files in HEAD.
 
 USAGE: 
     softgrep [OPTIONS] QUERY [PATH...]
+    softgrep [OPTIONS] -- QUERY [PATH...]
     softgrep [OPTIONS] QUERY
     command | softgrep [OPTIONS] QUERY
+    softgrep index [OPTIONS] [PATH...]
+    softgrep search [OPTIONS] QUERY
+    softgrep status [OPTIONS]
+    softgrep gc [OPTIONS]
+    softgrep config --show [OPTIONS]
 
 ARGS:
     <QUERY>
         A textual search query to dual-embed against the contents of the
-        files
+        files. A query that is the name of a command, such as index, must
+        follow --, as in softgrep -- index.
     <PATH...>
         Files or directories to search. Directories are searched recursively.
 
+COMMANDS:
+    index: Build or update the persistent index in --index-dir. The paths
+        the index was last built from are indexed again, along with any
+        new PATH. Unchanged chunks are read from the cache.
+    search: Search the persistent index instead of the given paths.
+    status: Print the files, chunks and model of the persistent index, and
+        list files that changed since it was built.
+    gc: Delete cached embeddings that no persistent index uses.
+    config --show: Print the effective configuration.
+
+CONFIGURATION:
+    Settings are read from, in increasing order of precedence:
+        ~/.config/softgrep/config.toml, or under $XDG_CONFIG_HOME
+        .softgrep.toml in the working directory or its closest parent
+        SOFTGREP_* environment variables, e.g. SOFTGREP_BATCH_SIZE=64
+        command line options
+    Config files use the long option names as keys, e.g. batch-size = 64
+    or ignore = ["*.min.js", "vendor/"]. Lists in environment variables
+    are comma separated.
+
+    Credentials are only read from config files and the environment, so
+    they do not show up in the process list:
+        token: Bearer token sent with every request, e.g. SOFTGREP_TOKEN
+        api-key: API key sent with every request, e.g. SOFTGREP_API_KEY
+    Both require TLS.
+
 OPTIONS:
-    --stride: Number of tokens to use per chunk
+    --stride: Number of tokens or lines per chunk for files without a parser
+    --overlap: Number of tokens or lines shared by consecutive chunks
+    --stride-unit: Unit of --stride and --overlap, one of tokens or lines
+    --backend: What computes embeddings, one of triton for a Triton
+        inference server or static for a static embedding model on the CPU
+    --static-model FILE: safetensors file with a float32 [vocab, dim]
+        tensor named embeddings, for the static backend, with a row for
+        every token of the vocabulary. Without one, token vectors are
+        derived from a hash, which matches shared tokens only, and a
+        warning is printed.
+    --host: Hostname of the inference server
+    --port: gRPC port of the inference server
+    --model: Name of the embedding model on the inference server
+    --batch-size: Maximum number of chunks per inference request
+    --batch-timeout: Maximum time to wait for a batch to fill
+    --max-in-flight: Maximum number of concurrent inference requests
+    --stream: Send inference requests over bidirectional gRPC streams, one
+        per concurrent request allowed by --max-in-flight
+    --endpoint ADDR: Inference server to send requests to, as host:port or
+        dns:///name:port for every address name resolves to. May be given
+        more than once, and adds to the endpoints from config files.
+        Replaces --host and --port.
+    --balance: How requests are spread over endpoints, one of round-robin
+        or least-outstanding
+    --health-interval: Interval of readiness probes of each endpoint.
+        Endpoints that are not ready get no requests until they are again.
+    --request-timeout: Deadline of each attempt at an inference request
+    --retries: Number of times to retry a request that failed with
+        UNAVAILABLE, RESOURCE_EXHAUSTED or a deadline
+    --retry-backoff: Initial delay between retries. The delay doubles
+        after every retry and is randomized.
+    --retry-max-backoff: Maximum delay between retries
+    --breaker-threshold: Number of consecutive failed requests after which
+        requests wait for the server to recover instead of being sent to
+        it. Set to 0 to disable.
+    --breaker-cooldown: Time to wait before probing a failing server again
+    --keepalive-time: Interval of keepalive pings on an idle connection.
+        Set to 0 to disable.
+    --keepalive-timeout: Time to wait for a keepalive ping to be answered
+    --tls: Connect to the inference server over TLS. Implied by the other
+        --tls-* options.
+    --tls-ca FILE: PEM encoded CA to verify the server with, instead of the
+        system roots
+    --tls-cert FILE, --tls-key FILE: PEM encoded client certificate and key
+        for mutual TLS
+    --tls-server-name NAME: Name to verify the server certificate against,
+        instead of --host
+    --api-key-header NAME: Metadata key the API key is sent in
+    --cache-dir: Directory to cache embeddings in
+    --no-cache: Do not read or write cached embeddings
+    --top-k: Number of results to print
+    --ignore PATTERN: Skip files matching a gitignore pattern. May be given
+        more than once, and adds to the patterns from config files.
+    -A, --after-context NUM: Print NUM lines of context after each result
+    -B, --before-context NUM: Print NUM lines of context before each result
+    -C NUM: Print NUM lines of context before and after each result
+    --heading: Print the file path above results from that file
+    --no-heading: Print the file path on every line
+    --color WHEN: Colorize output, one of auto, always or never
+    --json: Print results as JSON Lines, see pkg/output/json.go for the schema
+    --vimgrep: Print the first line of each result as path:line:column:text
+    --index-dir: Directory of the persistent index
+    --index: Type of nearest neighbor index, one of flat or hnsw
+    --hnsw-m: Maximum number of neighbors per HNSW node
+    --hnsw-ef-construction: Size of the HNSW candidate list when inserting
+    --hnsw-ef-search: Size of the HNSW candidate list when searching
+    --quantize: Compress indexed vectors, one of none, sq8 or pq. Only
+        the flat index can be quantized, and cached embeddings are kept
+        at full precision either way. Indexes of fewer than 1024 chunks
+        are not compressed.
+    --pq-subspaces: Number of bytes per vector with product quantization
+    --rerank: Number of quantized candidates to re-score with the full
+        precision embeddings in the cache, none with --no-cache.
+    --mode: How results are ranked, one of semantic by embedding
+        similarity, lexical by BM25 score of the identifiers and words
+        shared with the query, or hybrid for both rankings fused. Lexical
+        search does not embed anything, so without --cross-encoder it
+        needs no inference server, and an index built with --mode lexical
+        can only be searched lexically.
+    --fusion: How hybrid search fuses rankings, one of rrf for reciprocal
+        rank fusion or weighted for a weighted sum of normalized scores
+    --lexical-weight: Weight of the lexical ranking in hybrid search,
+        between 0 and 1. The semantic ranking gets the rest.
+    --cross-encoder MODEL: Re-rank the best results with a cross-encoder
+        model on the inference server, which scores the query and each
+        chunk together. Needs the triton backend.
+    --cross-encoder-top NUM: Number of results to re-rank
 `
 
 func printUsage() {
 	log.Fatal(USAGE)
 }
 
-type ChunkSource struct {
-	Reader io.Reader // reader to read from
-	Type   string    // type to determine tree sitter parser. If empty, no type is used.
-	Name   string    // filename or - for STDIN
-}
-
-type Chunk struct {
-	Content string
-}
-
 func IsBinary(file *os.File) bool {
 	bytes := make([]byte, 1024)
 	n, _ := file.Read(bytes)
@@ -72,119 +184,227 @@ func IsBinary(file *os.File) bool {
 	return false
 }
 
-var NUM_WORKERS = runtime.NumCPU() - 1
+// settingFlag is a boolean flag that sets a string setting to value when
+// given, e.g. --heading, --no-heading and --json.
+type settingFlag struct {
+	setting *string
+	value   string
+}
 
-// TODO one goroutine per embed req
+func (f settingFlag) String() string   { return "" }
+func (f settingFlag) IsBoolFlag() bool { return true }
+func (f settingFlag) Set(s string) error {
+	b, err := strconv.ParseBool(s)
+	if b {
+		*f.setting = f.value
+	}
+	return err
+}
 
-func main() {
-	config := config.NewConfig()
+// contextFlag sets both the before and after context, like rg -C.
+type contextFlag struct {
+	before *int
+	after  *int
+}
 
+func (f contextFlag) String() string { return "" }
+func (f contextFlag) Set(s string) error {
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return err
+	}
+	*f.before, *f.after = n, n
+	return nil
+}
+
+// listFlag appends to a list setting each time it is given.
+type listFlag struct {
+	list *[]string
+}
+
+func (f listFlag) String() string { return "" }
+func (f listFlag) Set(s string) error {
+	*f.list = append(*f.list, s)
+	return nil
+}
+
+// registerFlags defines every option on fs. Each flag defaults to the
+// current value of its setting.
+func registerFlags(fs *flag.FlagSet, config *config.Config) {
+	fs.IntVar(&config.Stride, "stride", config.Stride, "")
+	fs.IntVar(&config.Overlap, "overlap", config.Overlap, "")
+	fs.StringVar(&config.StrideUnit, "stride-unit", config.StrideUnit, "")
+	fs.StringVar(&config.Backend, "backend", config.Backend, "")
+	fs.StringVar(&config.StaticModel, "static-model", config.StaticModel, "")
+	fs.StringVar(&config.Host, "host", config.Host, "")
+	fs.StringVar(&config.Port, "port", config.Port, "")
+	fs.StringVar(&config.Model, "model", config.Model, "")
+	fs.IntVar(&config.BatchSize, "batch-size", config.BatchSize, "")
+	fs.DurationVar(&config.BatchTimeout, "batch-timeout", config.BatchTimeout, "")
+	fs.IntVar(&config.MaxInFlight, "max-in-flight", config.MaxInFlight, "")
+	fs.BoolVar(&config.Stream, "stream", config.Stream, "")
+	fs.Var(listFlag{&config.Endpoints}, "endpoint", "")
+	fs.StringVar(&config.Balance, "balance", config.Balance, "")
+	fs.DurationVar(&config.HealthInterval, "health-interval", config.HealthInterval, "")
+	fs.DurationVar(&config.RequestTimeout, "request-timeout", config.RequestTimeout, "")
+	fs.IntVar(&config.Retries, "retries", config.Retries, "")
+	fs.DurationVar(&config.RetryBackoff, "retry-backoff", config.RetryBackoff, "")
+	fs.DurationVar(&config.RetryMaxBackoff, "retry-max-backoff", config.RetryMaxBackoff, "")
+	fs.IntVar(&config.BreakerThreshold, "breaker-threshold", config.BreakerThreshold, "")
+	fs.DurationVar(&config.BreakerCooldown, "breaker-cooldown", config.BreakerCooldown, "")
+	fs.DurationVar(&config.KeepaliveTime, "keepalive-time", config.KeepaliveTime, "")
+	fs.DurationVar(&config.KeepaliveTimeout, "keepalive-timeout", config.KeepaliveTimeout, "")
+	fs.BoolVar(&config.TLS, "tls", config.TLS, "")
+	fs.StringVar(&config.TLSCA, "tls-ca", config.TLSCA, "")
+	fs.StringVar(&config.TLSCert, "tls-cert", config.TLSCert, "")
+	fs.StringVar(&config.TLSKey, "tls-key", config.TLSKey, "")
+	fs.StringVar(&config.TLSServerName, "tls-server-name", config.TLSServerName, "")
+	fs.StringVar(&config.APIKeyHeader, "api-key-header", config.APIKeyHeader, "")
+	fs.StringVar(&config.CacheDir, "cache-dir", config.CacheDir, "")
+	fs.BoolVar(&config.NoCache, "no-cache", config.NoCache, "")
+	fs.IntVar(&config.TopK, "top-k", config.TopK, "")
+	fs.Var(listFlag{&config.Ignore}, "ignore", "")
+	fs.IntVar(&config.After, "A", config.After, "")
+	fs.IntVar(&config.After, "after-context", config.After, "")
+	fs.IntVar(&config.Before, "B", config.Before, "")
+	fs.IntVar(&config.Before, "before-context", config.Before, "")
+	fs.Var(contextFlag{&config.Before, &config.After}, "C", "")
+	fs.Var(settingFlag{&config.Heading, output.ALWAYS}, "heading", "")
+	fs.Var(settingFlag{&config.Heading, output.NEVER}, "no-heading", "")
+	fs.StringVar(&config.Color, "color", config.Color, "")
+	fs.Var(settingFlag{&config.Format, output.JSON}, "json", "")
+	fs.Var(settingFlag{&config.Format, output.VIMGREP}, "vimgrep", "")
+	fs.StringVar(&config.IndexDir, "index-dir", config.IndexDir, "")
+	fs.StringVar(&config.IndexType, "index", config.IndexType, "")
+	fs.IntVar(&config.HNSWM, "hnsw-m", config.HNSWM, "")
+	fs.IntVar(&config.HNSWEfConstruction, "hnsw-ef-construction", config.HNSWEfConstruction, "")
+	fs.IntVar(&config.HNSWEfSearch, "hnsw-ef-search", config.HNSWEfSearch, "")
+	fs.StringVar(&config.Quantize, "quantize", config.Quantize, "")
+	fs.IntVar(&config.PQSubspaces, "pq-subspaces", config.PQSubspaces, "")
+	fs.IntVar(&config.Rerank, "rerank", config.Rerank, "")
+	fs.StringVar(&config.Mode, "mode", config.Mode, "")
+	fs.StringVar(&config.Fusion, "fusion", config.Fusion, "")
+	fs.Float64Var(&config.LexicalWeight, "lexical-weight", config.LexicalWeight, "")
+	fs.StringVar(&config.CrossEncoder, "cross-encoder", config.CrossEncoder, "")
+	fs.IntVar(&config.CrossEncoderTop, "cross-encoder-top", config.CrossEncoderTop, "")
+}
+
+func main() {
+	config, sources, err := config.Load()
+	if err != nil {
+		log.Fatalf("Error: %s", err)
+	}
+	registerFlags(flag.CommandLine, &config)
 	flag.Usage = printUsage
 	flag.Parse()
 
 	args := flag.Args()
-	var entryPaths []string
 	if len(args) == 0 {
-		cwd, err := os.Getwd()
-		if err != nil {
-			log.Panicf("Error: Error getting working directory: %s", err)
-		}
-		entryPaths = []string{cwd}
-	} else {
-		entryPaths = args
-	}
-
-	parseCh := make(chan ChunkSource, NUM_WORKERS)
-	var wg sync.WaitGroup
-
-	chunkCh := make(chan string)
-	for i := 0; i < NUM_WORKERS; i++ {
-		wg.Add(1)
-		go func(i int) {
-			defer wg.Done()
-			var entry ChunkSource
-			defer func() {
-				if r := recover(); r != nil {
-					log.Fatalf("Recovered in worker %d: %s: %s", i, entry.Name, r)
-				}
-			}()
-			for entry = range parseCh {
-				chunker, err := chunk.NewChunker(entry.Name, entry.Reader, &config)
-				if err != nil {
-                    if err == chunk.BinaryFileError {
-                        log.Printf("Worker %d: skipping suspected binary file %s", i, entry.Name)
-                    } else {
-                        log.Printf("Worker %d: error parsing %s: %s", i, entry.Name, err)
-                    }
-					continue
-				}
-
-				for token, err := chunker.Next(); err == nil; token, err = chunker.Next() {
-					chunkCh <- token
-				}
-				if err != io.EOF {
-					log.Printf("Error: Error parsing %s: %s", entry.Name, err)
-				}
-
-				if closer, ok := entry.Reader.(io.Closer); ok {
-					entry.Reader = nil
-					closer.Close()
-				}
-			}
-		}(i)
-	}
-
-    tokenCh := make(chan *tokenize.TokenizedChunk, 512)
-    for i := 0; i < NUM_WORKERS; i++ {
-        wg.Add(1)
-        go func(i int) {
-            defer wg.Done()
-            for chunk := range chunkCh {
-                t := tokenize.NewTokenizer(chunk)
-                for token := t.Next(); token != nil; token = t.Next() {
-                    tokenCh <- token
-                }
-            }
-        }(i)
-    }
-
-	emitter := func(osPathname string, file *os.File) error {
-        println(osPathname)
-		parseCh <- ChunkSource{
-			Name:   osPathname,
-			Reader: file,
+		printUsage()
+	}
+
+	// a query that is also a command name is searched for after --
+	command := args[0]
+	if n := len(os.Args) - len(args); os.Args[n-1] == "--" {
+		command = ""
+	}
+
+	// the first Ctrl-C cancels the pipeline, a second one kills the process
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		// restore the default handlers once the first signal arrives
+		<-ctx.Done()
+		stop()
+	}()
+	switch command {
+	case "config":
+		fs := flag.NewFlagSet(args[0], flag.ExitOnError)
+		registerFlags(fs, &config)
+		show := fs.Bool("show", false, "")
+		fs.Usage = printUsage
+		fs.Parse(args[1:])
+		if !*show || fs.NArg() > 0 {
+			printUsage()
 		}
-		return nil
-	}
-	w := walker.NewWalker(emitter)
-
-
-    go func () {
-        for t := range tokenCh {
-            log.Println(t.String())
-        }
-    }()
-
-	useStdin := false
-	for _, path := range entryPaths {
-		if path == "-" && !useStdin {
-			stdinInfo, _ := os.Stdin.Stat()
-			if (stdinInfo.Mode() & os.ModeCharDevice) == 0 {
-				parseCh <- ChunkSource{
-					Reader: os.Stdin,
-					Name:   "-",
-				}
-				useStdin = true
-			} else {
-				log.Panic("Error: Pipe not found")
-			}
-		} else {
-			err := w.Walk(path)
-			if err != nil {
-				log.Panic(err)
-			}
+		err = showConfig(&config, sources)
+	case "index", "search", "status", "gc":
+		// options may also follow the command
+		fs := flag.NewFlagSet(args[0], flag.ExitOnError)
+		registerFlags(fs, &config)
+		fs.Usage = printUsage
+		fs.Parse(args[1:])
+		err = runCommand(ctx, &config, args[0], fs.Args())
+	default:
+		err = run(ctx, &config, args[0], args[1:])
+	}
+	stop()
+	if err != nil {
+		if errors.Is(err, context.Canceled) {
+			os.Exit(130)
 		}
+		log.Printf("Error: %s", err)
+		os.Exit(2)
 	}
+}
 
-    wg.Wait()
+// serverError adds the address of the inference server to err.
+func serverError(config *config.Config, err error) error {
+	if len(config.Endpoints) > 0 {
+		return fmt.Errorf("inference servers %s: %w", strings.Join(config.Endpoints, ", "), err)
+	}
+	return fmt.Errorf("inference server %s:%s: %w", config.Host, config.Port, err)
+}
+
+// defaultPaths returns paths, or the working directory if paths is empty.
+func defaultPaths(paths []string) ([]string, error) {
+	if len(paths) > 0 {
+		return paths, nil
+	}
+	cwd, err := os.Getwd()
+	if err != nil {
+		return nil, fmt.Errorf("getting working directory: %w", err)
+	}
+	return []string{cwd}, nil
+}
+
+// run searches entryPaths for query and prints the results.
+func run(ctx context.Context, config *config.Config, query string, entryPaths []string) error {
+	entryPaths, err := defaultPaths(entryPaths)
+	if err != nil {
+		return err
+	}
+	printer, err := output.New(os.Stdout, config)
+	if err != nil {
+		return err
+	}
+
+	ix, err := softgrep.NewIndexer(softgrep.Options{Config: config})
+	if err != nil {
+		return err
+	}
+	defer ix.Close()
+	if err := ix.Check(ctx); err != nil {
+		return serverError(config, err)
+	}
+	if err := ix.Index(ctx, entryPaths); err != nil {
+		return err
+	}
+
+	results, err := ix.Searcher().Search(ctx, query, config.TopK)
+	if err != nil {
+		return err
+	}
+	return printResults(printer, results)
+}
+
+func printResults(printer output.Printer, results []softgrep.Result) error {
+	for _, r := range results {
+		if err := printer.Print(r); err != nil {
+			return fmt.Errorf("printing results: %w", err)
+		}
+	}
+	if err := printer.Close(); err != nil {
+		return fmt.Errorf("printing results: %w", err)
+	}
+	return nil
 }
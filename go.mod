@@ -3,16 +3,18 @@ module github.com/skrider/softgrep
 go 1.19
 
 require (
+	github.com/BurntSushi/toml v1.3.2
+	github.com/daulet/tokenizers v0.5.1
 	github.com/denormal/go-gitignore v0.0.0-20180930084346-ae8ad1d07817
 	github.com/karrick/godirwalk v1.17.0
 	github.com/smacker/go-tree-sitter v0.0.0-20230501083651-a7d92773b3aa
+	golang.org/x/sync v0.2.0
 	google.golang.org/grpc v1.56.1
 	google.golang.org/protobuf v1.30.0
 )
 
 require (
 	github.com/danwakefield/fnmatch v0.0.0-20160403171240-cbb64ac3d964 // indirect
-	github.com/daulet/tokenizers v0.5.1 // indirect
 	github.com/golang/protobuf v1.5.3 // indirect
 	golang.org/x/net v0.9.0 // indirect
 	golang.org/x/sys v0.7.0 // indirect
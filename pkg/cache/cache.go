@@ -0,0 +1,141 @@
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Cache is a persistent store of embeddings on disk. Entries are keyed by a
+// hash of the chunk text together with the model and tokenizer that produced
+// the embedding, so changing either invalidates every entry. Each entry is
+// stored in its own file, which makes concurrent reads and writes from
+// multiple workers safe without any locking. The entries of each model,
+// version and tokenizer live in a directory of their own, so that they can
+// be listed without touching those of other models.
+type Cache struct {
+	dir       string
+	model     string
+	version   string
+	tokenizer string
+}
+
+// NAMESPACE_LEN is the length of the directory name that holds the entries
+// of one model, version and tokenizer.
+const NAMESPACE_LEN = 16
+
+func NewCache(dir string, model string, version string, tokenizer string) (*Cache, error) {
+	h := sha256.New()
+	for _, s := range []string{model, version, tokenizer} {
+		h.Write([]byte(s))
+		h.Write([]byte{0})
+	}
+	dir = filepath.Join(dir, hex.EncodeToString(h.Sum(nil))[:NAMESPACE_LEN])
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &Cache{
+		dir:       dir,
+		model:     model,
+		version:   version,
+		tokenizer: tokenizer,
+	}, nil
+}
+
+func (c *Cache) Key(text string) string {
+	h := sha256.New()
+	for _, s := range []string{c.model, c.version, c.tokenizer} {
+		h.Write([]byte(s))
+		h.Write([]byte{0})
+	}
+	h.Write([]byte(text))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (c *Cache) path(key string) string {
+	return filepath.Join(c.dir, key[:2], key[2:])
+}
+
+// Get returns the embedding stored under key. A missing, unreadable or
+// empty entry is reported as a miss.
+func (c *Cache) Get(key string) ([]float32, bool) {
+	b, err := os.ReadFile(c.path(key))
+	if err != nil || len(b) == 0 || len(b)%4 != 0 {
+		return nil, false
+	}
+	v := make([]float32, len(b)/4)
+	for i := range v {
+		v[i] = math.Float32frombits(binary.LittleEndian.Uint32(b[4*i:]))
+	}
+	return v, true
+}
+
+// Put stores v under key. The entry is written to a temporary file and
+// renamed into place so readers never observe a partial write.
+func (c *Cache) Put(key string, v []float32) error {
+	b := make([]byte, 4*len(v))
+	for i, f := range v {
+		binary.LittleEndian.PutUint32(b[4*i:], math.Float32bits(f))
+	}
+
+	p := c.path(key)
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(p), ".tmp-")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), p)
+}
+
+// Delete removes the entry stored under key. Deleting a missing entry is not
+// an error.
+func (c *Cache) Delete(key string) error {
+	err := os.Remove(c.path(key))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// Keys calls fn with the key of every entry in the cache produced by its
+// model, version and tokenizer. Entries written while Keys runs may be
+// missed.
+func (c *Cache) Keys(fn func(key string) error) error {
+	prefixes, err := os.ReadDir(c.dir)
+	if err != nil {
+		return err
+	}
+	for _, prefix := range prefixes {
+		if !prefix.IsDir() || len(prefix.Name()) != 2 {
+			continue
+		}
+		entries, err := os.ReadDir(filepath.Join(c.dir, prefix.Name()))
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			if entry.IsDir() || strings.HasPrefix(entry.Name(), ".tmp-") {
+				continue
+			}
+			if err := fn(prefix.Name() + entry.Name()); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+package cache
+
+import (
+	"os"
+	"testing"
+)
+
+func TestGetPut(t *testing.T) {
+	dir := t.TempDir()
+	c, err := NewCache(dir, "codebert", "1", "fingerprint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := c.Key("func main() {}")
+	if _, ok := c.Get(key); ok {
+		t.Fatal("expected a miss before Put")
+	}
+	if err := c.Put(key, []float32{1, -2, 0.5}); err != nil {
+		t.Fatal(err)
+	}
+	v, ok := c.Get(key)
+	if !ok || len(v) != 3 || v[0] != 1 || v[1] != -2 || v[2] != 0.5 {
+		t.Fatalf("expected [1 -2 0.5], got %v, %v", v, ok)
+	}
+
+	// entries left behind by a failed write are misses
+	for _, b := range [][]byte{{}, {1, 2, 3}} {
+		if err := os.WriteFile(c.path(key), b, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if v, ok := c.Get(key); ok {
+			t.Errorf("expected a miss for a %d byte entry, got %v", len(b), v)
+		}
+	}
+
+	other, err := NewCache(dir, "codebert", "2", "fingerprint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other.Key("func main() {}") == key {
+		t.Error("expected keys to differ between model versions")
+	}
+}
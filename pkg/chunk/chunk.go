@@ -1,51 +1,139 @@
 package chunk
 
 import (
+	"errors"
 	"io"
-	"strings"
+	"unicode/utf8"
 
 	"github.com/skrider/softgrep/pkg/config"
 	sitter "github.com/smacker/go-tree-sitter"
 )
 
-type Chunker interface {
-	Next() (string, error)
+// Point is a zero-based row and byte column in a file.
+type Point struct {
+	Row    uint32
+	Column uint32
 }
 
-type TSChunker struct {
-	b    []byte
-	qc   *sitter.QueryCursor
-	lang *Language
-	tree *sitter.Tree
+// Chunk is a contiguous region of a file along with where it came from.
+type Chunk struct {
+	Path      string
+	Content   string
+	StartByte uint32
+	EndByte   uint32
+	Start     Point
+	End       Point
+	Language  string // empty if the file was not parsed
+	Query     string // name of the query that matched, empty if strided
+}
+
+// advance returns the point reached after reading s starting at p.
+func advance(p Point, s string) Point {
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\n' {
+			p.Row++
+			p.Column = 0
+		} else {
+			p.Column++
+		}
+	}
+	return p
 }
 
-func (t *TSChunker) Next() (string, error) {
-	m, ok := t.qc.NextMatch()
-	if !ok {
-		return "", io.EOF
+// runeStart moves i back to the start of the UTF-8 sequence it falls in.
+// Invalid UTF-8 moves it back by at most utf8.UTFMax-1 bytes.
+func runeStart(s string, i int) int {
+	if i >= len(s) {
+		return i
 	}
-	m = t.qc.FilterPredicates(m, t.b)
-	var builder strings.Builder
-	for _, c := range m.Captures {
-		builder.WriteString(c.Node.Content(t.b))
+	for j := i; j >= 0 && i-j < utf8.UTFMax; j-- {
+		if utf8.RuneStart(s[j]) {
+			return j
+		}
+	}
+	return i
+}
+
+// Slice returns the sub-chunk covering Content[start:end]. Out of range
+// offsets are clamped to the chunk, and offsets inside a multibyte
+// character are moved back to its first byte, so that slicing at token
+// boundaries never splits a character and adjacent slices stay adjacent.
+func (c *Chunk) Slice(start int, end int) *Chunk {
+	if end > len(c.Content) {
+		end = len(c.Content)
+	}
+	if start > end {
+		start = end
 	}
-	return builder.String(), nil
+	start, end = runeStart(c.Content, start), runeStart(c.Content, end)
+	sub := *c
+	sub.Content = c.Content[start:end]
+	sub.StartByte = c.StartByte + uint32(start)
+	sub.EndByte = c.StartByte + uint32(end)
+	sub.Start = advance(c.Start, c.Content[:start])
+	sub.End = advance(sub.Start, sub.Content)
+	return &sub
+}
+
+type Chunker interface {
+	// Next returns the next chunk, or io.EOF once the file is exhausted.
+	Next() (*Chunk, error)
 }
 
-type StridedChunker struct {
-	b       []byte
-	prevEnd int
-	stride  int
-	overlap int
+type TSChunker struct {
+	b     []byte
+	qc    *sitter.QueryCursor
+	lang  *Language
+	query string
+	tree  *sitter.Tree
+	path  string
 }
 
-func (t *StridedChunker) Next() (string, error) {
-	return string(t.b), io.EOF
+func (t *TSChunker) Next() (*Chunk, error) {
+	for {
+		m, ok := t.qc.NextMatch()
+		if !ok {
+			return nil, io.EOF
+		}
+		m = t.qc.FilterPredicates(m, t.b)
+		if len(m.Captures) == 0 {
+			continue
+		}
+
+		// a match may capture several nodes, so take the range spanning all of them
+		first, last := m.Captures[0].Node, m.Captures[0].Node
+		for _, c := range m.Captures[1:] {
+			if c.Node.StartByte() < first.StartByte() {
+				first = c.Node
+			}
+			if c.Node.EndByte() > last.EndByte() {
+				last = c.Node
+			}
+		}
+		start, end := first.StartPoint(), last.EndPoint()
+		return &Chunk{
+			Path:      t.path,
+			Content:   string(t.b[first.StartByte():last.EndByte()]),
+			StartByte: first.StartByte(),
+			EndByte:   last.EndByte(),
+			Start:     Point{Row: start.Row, Column: start.Column},
+			End:       Point{Row: end.Row, Column: end.Column},
+			Language:  t.lang.Name,
+			Query:     t.query,
+		}, nil
+	}
 }
 
-var BinaryFileError error
+var BinaryFileError = errors.New("chunk: suspected binary file")
 
-func NewChunker(filename string, reader io.Reader, config *config.Config) (Chunker, error) {
+// TokenFunc returns the byte offset at which each token of text ends.
+type TokenFunc func(text string) []int
+
+// NewChunker returns a chunker for the contents of reader. Files in a
+// language with tree-sitter queries are chunked by query match, everything
+// else is split into overlapping windows. tokens is used to measure windows
+// when config.StrideUnit is STRIDE_TOKENS.
+func NewChunker(filename string, reader io.Reader, config *config.Config, tokens TokenFunc) (Chunker, error) {
 	var lang *Language
 	for _, l := range Languages {
 		if l.FilePattern.MatchString(filename) {
@@ -70,12 +158,11 @@ func NewChunker(filename string, reader io.Reader, config *config.Config) (Chunk
     }
 
 	if lang == nil || lang.Strided {
-		return &StridedChunker{
-			b:       b,
-			prevEnd: 0,
-			stride:  config.Stride,
-			overlap: config.Overlap,
-		}, nil
+		language := ""
+		if lang != nil {
+			language = lang.Name
+		}
+		return newStridedChunker(b, filename, language, config, tokens)
 	}
 
 	parser := sitter.NewParser()
@@ -91,9 +178,11 @@ func NewChunker(filename string, reader io.Reader, config *config.Config) (Chunk
 	qc.Exec(q, n)
 
 	return &TSChunker{
-		b:    b,
-		tree: tree,
-		lang: lang,
-		qc:   qc,
+		b:     b,
+		tree:  tree,
+		lang:  lang,
+		query: lang.Queries[0].Name,
+		qc:    qc,
+		path:  filename,
 	}, nil
 }
@@ -0,0 +1,46 @@
+package chunk
+
+import (
+	"testing"
+	"unicode/utf8"
+)
+
+func TestSlice(t *testing.T) {
+	// "é" is two bytes and "世" three
+	c := &Chunk{Content: "é\n世界 ok", StartByte: 10, Start: Point{Row: 2, Column: 4}}
+	cases := []struct {
+		start, end int
+		want       string
+		startPoint Point
+	}{
+		{0, 2, "é", Point{2, 4}},
+		{0, 1, "", Point{2, 4}},
+		{1, 4, "é\n", Point{2, 4}},
+		{3, 5, "", Point{3, 0}},
+		{3, 7, "世", Point{3, 0}},
+		{4, 8, "世", Point{3, 0}},
+		{7, 100, "界 ok", Point{3, 3}},
+		{100, 200, "", Point{3, 9}},
+	}
+	for _, tc := range cases {
+		sub := c.Slice(tc.start, tc.end)
+		if sub.Content != tc.want || !utf8.ValidString(sub.Content) {
+			t.Errorf("Slice(%d, %d) = %q, want %q", tc.start, tc.end, sub.Content, tc.want)
+		}
+		if sub.Start != tc.startPoint {
+			t.Errorf("Slice(%d, %d) starts at %v, want %v", tc.start, tc.end, sub.Start, tc.startPoint)
+		}
+		if int(sub.EndByte-sub.StartByte) != len(sub.Content) || sub.StartByte < c.StartByte {
+			t.Errorf("Slice(%d, %d) covers bytes %d-%d for %q", tc.start, tc.end, sub.StartByte, sub.EndByte, sub.Content)
+		}
+	}
+
+	// adjacent slices at arbitrary byte offsets still cover the content
+	var joined string
+	for start := 0; start < len(c.Content); start += 2 {
+		joined += c.Slice(start, start+2).Content
+	}
+	if joined != c.Content {
+		t.Errorf("adjacent slices join to %q, want %q", joined, c.Content)
+	}
+}
@@ -0,0 +1,140 @@
+package chunk
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/skrider/softgrep/pkg/config"
+)
+
+const STRIDE_TOKENS = "tokens"
+const STRIDE_LINES = "lines"
+
+// span is a byte range of the file along with its size in stride units.
+type span struct {
+	start int
+	end   int
+	cost  int
+}
+
+// StridedChunker splits a file into windows of at most stride units that
+// overlap by at most overlap units, where a unit is either a token or a
+// line. Windows break at line boundaries unless a single line is longer than
+// stride tokens, in which case the line is split between tokens.
+type StridedChunker struct {
+	b        []byte
+	spans    []span
+	next     int
+	stride   int
+	overlap  int
+	path     string
+	language string
+
+	// position of posByte, so locations can be computed incrementally
+	pos     Point
+	posByte int
+}
+
+func newStridedChunker(b []byte, path string, language string, config *config.Config, tokens TokenFunc) (*StridedChunker, error) {
+	if config.Stride < 1 {
+		return nil, fmt.Errorf("chunk: stride must be positive, got %d", config.Stride)
+	}
+	if config.Overlap < 0 || config.Overlap >= config.Stride {
+		return nil, fmt.Errorf("chunk: overlap must be between 0 and stride, got %d", config.Overlap)
+	}
+
+	var spans []span
+	switch config.StrideUnit {
+	case STRIDE_LINES:
+		spans = lineSpans(b)
+	case STRIDE_TOKENS:
+		if tokens == nil {
+			return nil, fmt.Errorf("chunk: no tokenizer to measure stride in tokens")
+		}
+		spans = tokenSpans(b, tokens(string(b)), config.Stride)
+	default:
+		return nil, fmt.Errorf("chunk: unknown stride unit %q", config.StrideUnit)
+	}
+
+	return &StridedChunker{
+		b:        b,
+		spans:    spans,
+		stride:   config.Stride,
+		overlap:  config.Overlap,
+		path:     path,
+		language: language,
+	}, nil
+}
+
+// lineSpans returns one span per line, each costing one unit. The newline
+// belongs to the line it terminates.
+func lineSpans(b []byte) []span {
+	var spans []span
+	start := 0
+	for i, c := range b {
+		if c == '\n' {
+			spans = append(spans, span{start: start, end: i + 1, cost: 1})
+			start = i + 1
+		}
+	}
+	if start < len(b) {
+		spans = append(spans, span{start: start, end: len(b), cost: 1})
+	}
+	return spans
+}
+
+// tokenSpans returns one span per line costing the number of tokens that end
+// on that line. Lines of more than stride tokens are split between tokens.
+func tokenSpans(b []byte, ends []int, stride int) []span {
+	var spans []span
+	t := 0
+	for _, line := range lineSpans(b) {
+		start, cost := line.start, 0
+		for t < len(ends) && ends[t] <= line.end {
+			cost++
+			if cost == stride && ends[t] < line.end {
+				spans = append(spans, span{start: start, end: ends[t], cost: cost})
+				start, cost = ends[t], 0
+			}
+			t++
+		}
+		spans = append(spans, span{start: start, end: line.end, cost: cost})
+	}
+	return spans
+}
+
+func (t *StridedChunker) Next() (*Chunk, error) {
+	if t.next >= len(t.spans) {
+		return nil, io.EOF
+	}
+
+	first := t.next
+	last, cost := first, 0
+	for last < len(t.spans) && (last == first || cost+t.spans[last].cost <= t.stride) {
+		cost += t.spans[last].cost
+		last++
+	}
+
+	// back up so the next window repeats up to overlap units of this one,
+	// while always making progress
+	t.next = last
+	if last < len(t.spans) {
+		for back := 0; t.next-1 > first && back+t.spans[t.next-1].cost <= t.overlap; t.next-- {
+			back += t.spans[t.next-1].cost
+		}
+	}
+
+	start, end := t.spans[first].start, t.spans[last-1].end
+	content := string(t.b[start:end])
+	t.pos = advance(t.pos, string(t.b[t.posByte:start]))
+	t.posByte = start
+	return &Chunk{
+		Path:      t.path,
+		Content:   content,
+		StartByte: uint32(start),
+		EndByte:   uint32(end),
+		Start:     t.pos,
+		End:       advance(t.pos, content),
+		Language:  t.language,
+	}, nil
+}
@@ -0,0 +1,95 @@
+package chunk
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/skrider/softgrep/pkg/config"
+)
+
+// wordEnds treats every space separated word as a token.
+func wordEnds(text string) []int {
+	var ends []int
+	for i := 0; i < len(text); i++ {
+		if text[i] != ' ' && text[i] != '\n' && (i+1 == len(text) || text[i+1] == ' ' || text[i+1] == '\n') {
+			ends = append(ends, i+1)
+		}
+	}
+	return ends
+}
+
+func collect(t *testing.T, c Chunker) []*Chunk {
+	var chunks []*Chunk
+	for {
+		chunk, err := c.Next()
+		if err == io.EOF {
+			return chunks
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		chunks = append(chunks, chunk)
+	}
+}
+
+func TestStridedLines(t *testing.T) {
+	text := "a\nb\nc\nd\ne\nf\ng"
+	cfg := config.NewConfig()
+	cfg.Stride, cfg.Overlap, cfg.StrideUnit = 3, 1, STRIDE_LINES
+
+	c, err := NewChunker("file.txt", strings.NewReader(text), &cfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chunks := collect(t, c)
+
+	want := []struct {
+		content    string
+		start, end uint32
+	}{
+		{"a\nb\nc\n", 0, 3},
+		{"c\nd\ne\n", 2, 5},
+		{"e\nf\ng", 4, 6},
+	}
+	if len(chunks) != len(want) {
+		t.Fatalf("expected %d chunks, got %d", len(want), len(chunks))
+	}
+	for i, w := range want {
+		got := chunks[i]
+		if got.Content != w.content || got.Start.Row != w.start || got.End.Row != w.end {
+			t.Errorf("chunk %d: got %q rows %d-%d, want %q rows %d-%d",
+				i, got.Content, got.Start.Row, got.End.Row, w.content, w.start, w.end)
+		}
+		if text[got.StartByte:got.EndByte] != got.Content {
+			t.Errorf("chunk %d: byte range does not match content", i)
+		}
+	}
+}
+
+func TestStridedTokens(t *testing.T) {
+	text := "one two\nthree four five six seven\neight\n"
+	cfg := config.NewConfig()
+	cfg.Stride, cfg.Overlap, cfg.StrideUnit = 3, 1, STRIDE_TOKENS
+
+	c, err := NewChunker("file.txt", strings.NewReader(text), &cfg, wordEnds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chunks := collect(t, c)
+
+	// the second line is longer than the stride and is split between words
+	want := []string{
+		"one two\n",
+		"three four five",
+		" six seven\neight\n",
+	}
+	if len(chunks) != len(want) {
+		t.Fatalf("expected %d chunks, got %d", len(want), len(chunks))
+	}
+	for i, w := range want {
+		if chunks[i].Content != w {
+			t.Errorf("chunk %d: got %q, want %q", i, chunks[i].Content, w)
+		}
+	}
+}
@@ -1,13 +1,140 @@
 package config
 
+import "time"
+
+// Config holds every setting. The toml tags name each setting in config
+// files, and match the long name of its flag where it has one. See Load for
+// how settings are layered.
 type Config struct {
-	Stride  int
-	Overlap int
+	Stride       int           `toml:"stride"`
+	Overlap      int           `toml:"overlap"`
+	StrideUnit   string        `toml:"stride-unit"`
+	Backend      string        `toml:"backend"`
+	StaticModel  string        `toml:"static-model"`
+	Host         string        `toml:"host"`
+	Port         string        `toml:"port"`
+	Model        string        `toml:"model"`
+	ModelVersion string        `toml:"model-version"`
+	BatchSize    int           `toml:"batch-size"`
+	BatchTimeout time.Duration `toml:"batch-timeout"`
+	MaxInFlight  int           `toml:"max-in-flight"`
+	Stream       bool          `toml:"stream"`
+	// host:port or dns:///name:port of each inference server, replaces
+	// Host and Port when set
+	Endpoints      []string      `toml:"endpoint"`
+	Balance        string        `toml:"balance"`
+	HealthInterval time.Duration `toml:"health-interval"`
+
+	RequestTimeout   time.Duration `toml:"request-timeout"`
+	Retries          int           `toml:"retries"`
+	RetryBackoff     time.Duration `toml:"retry-backoff"`
+	RetryMaxBackoff  time.Duration `toml:"retry-max-backoff"`
+	BreakerThreshold int           `toml:"breaker-threshold"`
+	BreakerCooldown  time.Duration `toml:"breaker-cooldown"`
+	KeepaliveTime    time.Duration `toml:"keepalive-time"`
+	KeepaliveTimeout time.Duration `toml:"keepalive-timeout"`
+
+	TLS           bool   `toml:"tls"`
+	TLSCA         string `toml:"tls-ca"`
+	TLSCert       string `toml:"tls-cert"`
+	TLSKey        string `toml:"tls-key"`
+	TLSServerName string `toml:"tls-server-name"`
+	Token         string `toml:"token"`
+	APIKey        string `toml:"api-key"`
+	APIKeyHeader  string `toml:"api-key-header"`
+
+	CacheDir string `toml:"cache-dir"`
+	NoCache  bool   `toml:"no-cache"`
+	TopK     int    `toml:"top-k"`
+	// gitignore patterns of files to skip, relative to each searched path
+	Ignore []string `toml:"ignore"`
+
+	IndexDir           string `toml:"index-dir"`
+	IndexType          string `toml:"index"`
+	HNSWM              int    `toml:"hnsw-m"`
+	HNSWEfConstruction int    `toml:"hnsw-ef-construction"`
+	HNSWEfSearch       int    `toml:"hnsw-ef-search"`
+	Quantize           string `toml:"quantize"`
+	PQSubspaces        int    `toml:"pq-subspaces"`
+	Rerank             int    `toml:"rerank"`
+
+	Mode          string  `toml:"mode"`
+	Fusion        string  `toml:"fusion"`
+	LexicalWeight float64 `toml:"lexical-weight"`
+	// model re-ranking the best results, none if empty
+	CrossEncoder    string `toml:"cross-encoder"`
+	CrossEncoderTop int    `toml:"cross-encoder-top"`
+
+	Format  string `toml:"format"`
+	Color   string `toml:"color"`
+	Heading string `toml:"heading"`
+	Before  int    `toml:"before-context"`
+	After   int    `toml:"after-context"`
 }
 
 func NewConfig() Config {
 	return Config{
-		Stride:  500,
-		Overlap: 50,
+		Stride:       500,
+		Overlap:      50,
+		StrideUnit:   "tokens",
+		Backend:      "triton",
+		StaticModel:  "",
+		Host:         "localhost",
+		Port:         "8001",
+		Model:        "codebert",
+		ModelVersion: "",
+		BatchSize:    32,
+		BatchTimeout: 50 * time.Millisecond,
+		MaxInFlight:  4,
+		Stream:       false,
+
+		Endpoints:      []string{},
+		Balance:        "round-robin",
+		HealthInterval: 5 * time.Second,
+
+		RequestTimeout:   30 * time.Second,
+		Retries:          8,
+		RetryBackoff:     250 * time.Millisecond,
+		RetryMaxBackoff:  10 * time.Second,
+		BreakerThreshold: 5,
+		BreakerCooldown:  5 * time.Second,
+		KeepaliveTime:    0,
+		KeepaliveTimeout: 20 * time.Second,
+
+		TLS:           false,
+		TLSCA:         "",
+		TLSCert:       "",
+		TLSKey:        "",
+		TLSServerName: "",
+		Token:         "",
+		APIKey:        "",
+		APIKeyHeader:  "x-api-key",
+
+		CacheDir: DefaultCacheDir(),
+		NoCache:  false,
+		TopK:     10,
+		Ignore:   []string{},
+
+		IndexDir:           ".softgrep",
+		IndexType:          "flat",
+		HNSWM:              16,
+		HNSWEfConstruction: 200,
+		HNSWEfSearch:       64,
+		Quantize:           "none",
+		PQSubspaces:        96,
+		Rerank:             100,
+
+		Mode:          "semantic",
+		Fusion:        "rrf",
+		LexicalWeight: 0.5,
+
+		CrossEncoder:    "",
+		CrossEncoderTop: 20,
+
+		Format:  "standard",
+		Color:   "auto",
+		Heading: "auto",
+		Before:  0,
+		After:   0,
 	}
 }
@@ -0,0 +1,191 @@
+package config
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+// REPO_FILE is the name of the per-repository config file. It applies to
+// the directory it is in and every directory below.
+const REPO_FILE = ".softgrep.toml"
+
+const ENV_PREFIX = "SOFTGREP_"
+
+// UserFile returns $XDG_CONFIG_HOME/softgrep/config.toml, defaulting to
+// ~/.config/softgrep/config.toml.
+func UserFile() string {
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		dir = filepath.Join(home, ".config")
+	}
+	return filepath.Join(dir, "softgrep", "config.toml")
+}
+
+// DefaultCacheDir returns $XDG_CACHE_HOME/softgrep, or the platform
+// equivalent.
+func DefaultCacheDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return filepath.Join(os.TempDir(), "softgrep")
+	}
+	return filepath.Join(dir, "softgrep")
+}
+
+// RepoFile returns the REPO_FILE in dir or the closest of its parents, or
+// the empty string if there is none.
+func RepoFile(dir string) string {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return ""
+	}
+	for {
+		path := filepath.Join(dir, REPO_FILE)
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// Load returns the configuration in effect in the working directory. Each
+// of these overrides the settings it mentions from the ones before it:
+//
+//   - the defaults from NewConfig
+//   - the user config file, see UserFile
+//   - the repository config file, see RepoFile
+//   - SOFTGREP_* environment variables, e.g. SOFTGREP_BATCH_SIZE=64
+//
+// Command line flags are applied on top by the caller. Load also returns
+// the files and environment variables that were applied, in order.
+func Load() (Config, []string, error) {
+	config := NewConfig()
+	var sources []string
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		return config, nil, err
+	}
+	for _, path := range []string{UserFile(), RepoFile(cwd)} {
+		if path == "" {
+			continue
+		}
+		ok, err := config.loadFile(path)
+		if err != nil {
+			return config, nil, err
+		}
+		if ok {
+			sources = append(sources, path)
+		}
+	}
+
+	env, err := config.loadEnv(os.LookupEnv)
+	if err != nil {
+		return config, nil, err
+	}
+	return config, append(sources, env...), nil
+}
+
+// loadFile applies the settings in the TOML file at path, reporting whether
+// the file exists. Unknown settings are an error so that typos do not go
+// unnoticed.
+func (c *Config) loadFile(path string) (bool, error) {
+	md, err := toml.DecodeFile(path, c)
+	if os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("config: %s: %w", path, err)
+	}
+	if undecoded := md.Undecoded(); len(undecoded) > 0 {
+		return false, fmt.Errorf("config: %s: unknown setting %q", path, undecoded[0].String())
+	}
+	return true, nil
+}
+
+// envName returns the environment variable for the setting with the given
+// toml name, e.g. SOFTGREP_BATCH_SIZE for batch-size.
+func envName(name string) string {
+	return ENV_PREFIX + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// loadEnv applies the settings found by lookup and returns the names of the
+// variables it used. Lists are comma separated.
+func (c *Config) loadEnv(lookup func(string) (string, bool)) ([]string, error) {
+	var used []string
+	v := reflect.ValueOf(c).Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		name := envName(t.Field(i).Tag.Get("toml"))
+		s, ok := lookup(name)
+		if !ok {
+			continue
+		}
+		if err := setValue(v.Field(i), s); err != nil {
+			return nil, fmt.Errorf("config: %s: %w", name, err)
+		}
+		used = append(used, name)
+	}
+	return used, nil
+}
+
+func setValue(field reflect.Value, s string) error {
+	switch field.Interface().(type) {
+	case string:
+		field.SetString(s)
+	case int:
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(n))
+	case float64:
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return err
+		}
+		field.SetFloat(f)
+	case bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+	case time.Duration:
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(d))
+	case []string:
+		list := []string{}
+		for _, item := range strings.Split(s, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+		field.Set(reflect.ValueOf(list))
+	default:
+		return fmt.Errorf("unsupported setting type %s", field.Type())
+	}
+	return nil
+}
+
+// Write writes c to w in the format of a config file.
+func (c *Config) Write(w io.Writer) error {
+	return toml.NewEncoder(w).Encode(c)
+}
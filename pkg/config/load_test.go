@@ -0,0 +1,79 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestLoadLayers(t *testing.T) {
+	home := t.TempDir()
+	repo := filepath.Join(t.TempDir(), "repo")
+	sub := filepath.Join(repo, "sub")
+	if err := os.MkdirAll(sub, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	user := filepath.Join(home, "softgrep", "config.toml")
+	if err := os.MkdirAll(filepath.Dir(user), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	write := func(path string, content string) {
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(user, "host = \"user\"\nport = \"1\"\nbatch-size = 8\n")
+	write(filepath.Join(repo, REPO_FILE), "port = \"2\"\nignore = [\"*.min.js\"]\nbatch-timeout = \"1s\"\n")
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(cwd)
+	if err := os.Chdir(sub); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("XDG_CONFIG_HOME", home)
+	t.Setenv("SOFTGREP_BATCH_SIZE", "64")
+	t.Setenv("SOFTGREP_NO_CACHE", "true")
+
+	config, sources, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Host != "user" || config.Port != "2" || config.BatchSize != 64 || !config.NoCache {
+		t.Errorf("settings not layered in order: %+v", config)
+	}
+	if config.BatchTimeout != time.Second || !reflect.DeepEqual(config.Ignore, []string{"*.min.js"}) {
+		t.Errorf("expected batch-timeout and ignore from the repo file, got %s and %q", config.BatchTimeout, config.Ignore)
+	}
+	if config.Model != NewConfig().Model {
+		t.Errorf("expected the default model, got %s", config.Model)
+	}
+	want := []string{user, filepath.Join(repo, REPO_FILE), "SOFTGREP_BATCH_SIZE", "SOFTGREP_NO_CACHE"}
+	if !reflect.DeepEqual(sources, want) {
+		t.Errorf("expected sources %q, got %q", want, sources)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.toml")
+	if err := os.WriteFile(path, []byte("stide = 10\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	config := NewConfig()
+	if _, err := config.loadFile(path); err == nil {
+		t.Error("expected an error for an unknown setting")
+	}
+
+	env := map[string]string{"SOFTGREP_STRIDE": "ten"}
+	lookup := func(name string) (string, bool) {
+		v, ok := env[name]
+		return v, ok
+	}
+	if _, err := config.loadEnv(lookup); err == nil {
+		t.Error("expected an error for a malformed integer")
+	}
+}
@@ -0,0 +1,276 @@
+package embed
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/skrider/softgrep/pb/triton-client"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+const ROUND_ROBIN = "round-robin"
+const LEAST_OUTSTANDING = "least-outstanding"
+
+type backend struct {
+	addr        string
+	conn        *grpc.ClientConn
+	client      triton_client.GRPCInferenceServiceClient
+	healthy     int32
+	outstanding int64
+}
+
+func (b *backend) isHealthy() bool {
+	return atomic.LoadInt32(&b.healthy) == 1
+}
+
+// setHealthy records the result of a probe and reports whether it changed
+// the backend's state.
+func (b *backend) setHealthy(healthy bool) bool {
+	var v int32
+	if healthy {
+		v = 1
+	}
+	return atomic.SwapInt32(&b.healthy, v) != v
+}
+
+// Balancer spreads requests over several inference servers serving the
+// same model. Servers that fail a ServerReady probe, or a request with
+// UNAVAILABLE, are ejected until a later probe finds them ready again.
+//
+// Balancer implements the inference client interface, so it can be used
+// anywhere a single client is. Inference and health requests are balanced;
+// any other request goes to the first server.
+type Balancer struct {
+	triton_client.GRPCInferenceServiceClient
+
+	backends []*backend
+	policy   string
+	next     uint64
+	interval time.Duration
+	done     chan struct{}
+	wg       sync.WaitGroup
+}
+
+// NewBalancer connects to every address and starts probing them every
+// interval. Addresses are dialed with opts, and are only ejected or
+// readmitted by probes and failed requests, never removed.
+func NewBalancer(addrs []Address, policy string, interval time.Duration, opts ...grpc.DialOption) (*Balancer, error) {
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("embed: no inference servers to balance over")
+	}
+	if policy != ROUND_ROBIN && policy != LEAST_OUTSTANDING {
+		return nil, fmt.Errorf("embed: unknown balancing policy %q", policy)
+	}
+
+	b := &Balancer{
+		policy:   policy,
+		interval: interval,
+		done:     make(chan struct{}),
+	}
+	for _, addr := range addrs {
+		// as in NewClient, opts may replace the insecure default
+		dialOpts := []grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}
+		dialOpts = append(dialOpts, opts...)
+		if addr.Authority != "" {
+			dialOpts = append(dialOpts, grpc.WithAuthority(addr.Authority))
+		}
+		conn, err := grpc.Dial(addr.Target, dialOpts...)
+		if err != nil {
+			b.Close()
+			return nil, err
+		}
+		b.backends = append(b.backends, &backend{
+			addr:    addr.Target,
+			conn:    conn,
+			client:  triton_client.NewGRPCInferenceServiceClient(conn),
+			healthy: 1,
+		})
+	}
+	b.GRPCInferenceServiceClient = b.backends[0].client
+
+	// start with an accurate view so the first requests avoid dead servers
+	b.probe()
+	if interval > 0 {
+		b.wg.Add(1)
+		go b.run()
+	}
+	return b, nil
+}
+
+func (b *Balancer) run() {
+	defer b.wg.Done()
+	ticker := time.NewTicker(b.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			b.probe()
+		case <-b.done:
+			return
+		}
+	}
+}
+
+// probe asks every backend whether it is ready, concurrently.
+func (b *Balancer) probe() {
+	timeout := b.interval
+	if timeout <= 0 || timeout > CHECK_TIMEOUT {
+		timeout = CHECK_TIMEOUT
+	}
+	var wg sync.WaitGroup
+	for _, be := range b.backends {
+		wg.Add(1)
+		go func(be *backend) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			defer cancel()
+			res, err := be.client.ServerReady(ctx, &triton_client.ServerReadyRequest{})
+			ready := err == nil && res.Ready
+			if be.setHealthy(ready) {
+				if ready {
+					log.Printf("Inference server %s is ready, readmitting it", be.addr)
+				} else {
+					log.Printf("Inference server %s is not ready, ejecting it", be.addr)
+				}
+			}
+		}(be)
+	}
+	wg.Wait()
+}
+
+// Check runs check against every server that is ready, and fails if none
+// is. The servers must all serve the same model, so a server that fails the
+// check is named in the error rather than ejected.
+func (b *Balancer) Check(check func(triton_client.GRPCInferenceServiceClient) error) error {
+	checked := 0
+	for _, be := range b.backends {
+		if !be.isHealthy() {
+			continue
+		}
+		if err := check(be.client); err != nil {
+			return fmt.Errorf("server %s: %w", be.addr, err)
+		}
+		checked++
+	}
+	if checked == 0 {
+		return status.Error(codes.Unavailable, "embed: no inference server is ready")
+	}
+	return nil
+}
+
+// Close stops probing and closes every connection.
+func (b *Balancer) Close() error {
+	select {
+	case <-b.done:
+	default:
+		close(b.done)
+	}
+	b.wg.Wait()
+	var firstErr error
+	for _, be := range b.backends {
+		if err := be.conn.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// pick returns a healthy backend chosen by the balancing policy.
+func (b *Balancer) pick() (*backend, error) {
+	n := uint64(len(b.backends))
+	start := atomic.AddUint64(&b.next, 1)
+	var best *backend
+	bestLoad := int64(math.MaxInt64)
+	for i := uint64(0); i < n; i++ {
+		be := b.backends[(start+i)%n]
+		if !be.isHealthy() {
+			continue
+		}
+		if b.policy == ROUND_ROBIN {
+			return be, nil
+		}
+		// ties go to the next backend in round-robin order
+		if load := atomic.LoadInt64(&be.outstanding); load < bestLoad {
+			best, bestLoad = be, load
+		}
+	}
+	if best == nil {
+		return nil, status.Error(codes.Unavailable, "embed: no inference server is ready")
+	}
+	return best, nil
+}
+
+// call runs fn against a picked backend, ejecting the backend if it turns
+// out to be unreachable.
+func (b *Balancer) call(fn func(c triton_client.GRPCInferenceServiceClient) error) error {
+	be, err := b.pick()
+	if err != nil {
+		return err
+	}
+	atomic.AddInt64(&be.outstanding, 1)
+	err = fn(be.client)
+	atomic.AddInt64(&be.outstanding, -1)
+	if status.Code(err) == codes.Unavailable && be.setHealthy(false) {
+		log.Printf("Inference server %s is unavailable, ejecting it: %s", be.addr, err)
+	}
+	return err
+}
+
+func (b *Balancer) ServerLive(ctx context.Context, in *triton_client.ServerLiveRequest, opts ...grpc.CallOption) (res *triton_client.ServerLiveResponse, err error) {
+	err = b.call(func(c triton_client.GRPCInferenceServiceClient) error {
+		res, err = c.ServerLive(ctx, in, opts...)
+		return err
+	})
+	return res, err
+}
+
+func (b *Balancer) ServerReady(ctx context.Context, in *triton_client.ServerReadyRequest, opts ...grpc.CallOption) (res *triton_client.ServerReadyResponse, err error) {
+	err = b.call(func(c triton_client.GRPCInferenceServiceClient) error {
+		res, err = c.ServerReady(ctx, in, opts...)
+		return err
+	})
+	return res, err
+}
+
+func (b *Balancer) ModelReady(ctx context.Context, in *triton_client.ModelReadyRequest, opts ...grpc.CallOption) (res *triton_client.ModelReadyResponse, err error) {
+	err = b.call(func(c triton_client.GRPCInferenceServiceClient) error {
+		res, err = c.ModelReady(ctx, in, opts...)
+		return err
+	})
+	return res, err
+}
+
+func (b *Balancer) ModelMetadata(ctx context.Context, in *triton_client.ModelMetadataRequest, opts ...grpc.CallOption) (res *triton_client.ModelMetadataResponse, err error) {
+	err = b.call(func(c triton_client.GRPCInferenceServiceClient) error {
+		res, err = c.ModelMetadata(ctx, in, opts...)
+		return err
+	})
+	return res, err
+}
+
+func (b *Balancer) ModelInfer(ctx context.Context, in *triton_client.ModelInferRequest, opts ...grpc.CallOption) (res *triton_client.ModelInferResponse, err error) {
+	err = b.call(func(c triton_client.GRPCInferenceServiceClient) error {
+		res, err = c.ModelInfer(ctx, in, opts...)
+		return err
+	})
+	return res, err
+}
+
+// ModelStreamInfer opens the stream on a picked backend. A stream stays on
+// its backend for its whole life, so outstanding requests on streams are
+// not counted.
+func (b *Balancer) ModelStreamInfer(ctx context.Context, opts ...grpc.CallOption) (triton_client.GRPCInferenceService_ModelStreamInferClient, error) {
+	be, err := b.pick()
+	if err != nil {
+		return nil, err
+	}
+	return be.client.ModelStreamInfer(ctx, opts...)
+}
@@ -0,0 +1,89 @@
+package embed_test
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/skrider/softgrep/pkg/embed"
+	"github.com/skrider/softgrep/pkg/embed/embedtest"
+)
+
+func TestBalance(t *testing.T) {
+	for _, policy := range []string{embed.ROUND_ROBIN, embed.LEAST_OUTSTANDING} {
+		servers := embedtest.NewServers(t, 3)
+		cfg := embedtest.NewConfig(t, servers...)
+		cfg.Balance = policy
+		e := embedtest.NewEmbedder(t, cfg)
+
+		for i := 0; i < 30; i++ {
+			if err := embedOne(e); err != nil {
+				t.Fatalf("%s: %v", policy, err)
+			}
+		}
+		for i, s := range servers {
+			if n := s.Infers(); n != 10 {
+				t.Errorf("%s: expected 10 requests to server %d, got %d", policy, i, n)
+			}
+		}
+	}
+}
+
+func TestBalanceHealth(t *testing.T) {
+	servers := embedtest.NewServers(t, 3)
+	servers[1].NotReady = true
+	cfg := embedtest.NewConfig(t, servers...)
+	cfg.Retries = 2
+	cfg.RetryBackoff = time.Millisecond
+	cfg.HealthInterval = 20 * time.Millisecond
+	e := embedtest.NewEmbedder(t, cfg)
+
+	// the unready server is ejected by the first probe
+	for i := 0; i < 10; i++ {
+		if err := embedOne(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := servers[1].Infers(); n != 0 {
+		t.Errorf("expected no requests to the unready server, got %d", n)
+	}
+
+	// a failed request ejects a server until the next probe readmits it
+	servers[2].Fail(1)
+	for i := 0; i < 4; i++ {
+		if err := embedOne(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	before := servers[2].Infers()
+	time.Sleep(10 * cfg.HealthInterval)
+	for i := 0; i < 10; i++ {
+		if err := embedOne(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if servers[2].Infers() == before {
+		t.Errorf("expected the failed server to be readmitted")
+	}
+}
+
+func TestBalanceCheck(t *testing.T) {
+	servers := embedtest.NewServers(t, 3)
+	e := embedtest.NewEmbedder(t, embedtest.NewConfig(t, servers...))
+	if err := e.Check(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// every server is checked, not just the one a request happens to reach
+	servers = embedtest.NewServers(t, 3)
+	servers[2].Model = "other"
+	e = embedtest.NewEmbedder(t, embedtest.NewConfig(t, servers...))
+	for i := 0; i < 3; i++ {
+		err := e.Check(context.Background())
+		if err == nil || !strings.Contains(err.Error(), net.JoinHostPort(servers[2].Host, servers[2].Port)) {
+			t.Fatalf("expected the check to fail for the server without the model, got %v", err)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package embed
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/skrider/softgrep/pkg/tokenize"
+	"golang.org/x/sync/errgroup"
+)
+
+// Batcher groups tokenized chunks into batched inference requests. A batch
+// is sent once it holds size chunks or once timeout has elapsed since its
+// first chunk arrived, whichever comes first.
+type Batcher struct {
+	embedder Embedder
+	size     int
+	timeout  time.Duration
+	inFlight int
+}
+
+func NewBatcher(embedder Embedder, size int, timeout time.Duration, inFlight int) *Batcher {
+	if size < 1 {
+		size = 1
+	}
+	if inFlight < 1 {
+		inFlight = 1
+	}
+	return &Batcher{
+		embedder: embedder,
+		size:     size,
+		timeout:  timeout,
+		inFlight: inFlight,
+	}
+}
+
+// Run embeds chunks read from in and sends them to out once their batch
+// completes. At most inFlight batches are outstanding at any time. Run
+// returns after in is closed and every pending batch has finished, or with
+// the first error once a batch fails or ctx is cancelled.
+func (b *Batcher) Run(ctx context.Context, in <-chan *tokenize.TokenizedChunk, out chan<- *tokenize.TokenizedChunk) error {
+	g, ctx := errgroup.WithContext(ctx)
+	g.SetLimit(b.inFlight)
+
+	batch := make([]*tokenize.TokenizedChunk, 0, b.size)
+	var deadline <-chan time.Time
+
+	flush := func() {
+		deadline = nil
+		if len(batch) == 0 {
+			return
+		}
+		full := batch
+		g.Go(func() error {
+			if err := b.embedder.Embed(ctx, full); err != nil {
+				return fmt.Errorf("embedding batch of %d chunks: %w", len(full), err)
+			}
+			for _, c := range full {
+				select {
+				case out <- c:
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			}
+			return nil
+		})
+		batch = make([]*tokenize.TokenizedChunk, 0, b.size)
+	}
+
+	for {
+		select {
+		case c, ok := <-in:
+			if !ok {
+				flush()
+				return g.Wait()
+			}
+			if len(batch) == 0 {
+				deadline = time.After(b.timeout)
+			}
+			batch = append(batch, c)
+			if len(batch) >= b.size {
+				flush()
+			}
+		case <-deadline:
+			flush()
+		case <-ctx.Done():
+			if err := g.Wait(); err != nil {
+				return err
+			}
+			return ctx.Err()
+		}
+	}
+}
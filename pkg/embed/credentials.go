@@ -0,0 +1,88 @@
+package embed
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/skrider/softgrep/pkg/config"
+	"google.golang.org/grpc/credentials"
+)
+
+// tlsEnabled reports whether config asks for TLS, either explicitly or
+// through any other TLS setting.
+func tlsEnabled(config *config.Config) bool {
+	return config.TLS || config.TLSCA != "" || config.TLSCert != "" || config.TLSKey != "" || config.TLSServerName != ""
+}
+
+// tlsConfig returns the TLS settings selected by config. Without a CA the
+// system roots are used to verify the server. A client certificate is
+// presented for mutual TLS when both TLSCert and TLSKey are set.
+func tlsConfig(config *config.Config) (*tls.Config, error) {
+	c := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: config.TLSServerName,
+	}
+
+	if config.TLSCA != "" {
+		pem, err := os.ReadFile(config.TLSCA)
+		if err != nil {
+			return nil, fmt.Errorf("embed: reading CA: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("embed: no certificates found in %s", config.TLSCA)
+		}
+		c.RootCAs = pool
+	}
+
+	if (config.TLSCert == "") != (config.TLSKey == "") {
+		return nil, errors.New("embed: a client certificate needs both a certificate and a key")
+	}
+	if config.TLSCert != "" {
+		cert, err := tls.LoadX509KeyPair(config.TLSCert, config.TLSKey)
+		if err != nil {
+			return nil, fmt.Errorf("embed: loading client certificate: %w", err)
+		}
+		c.Certificates = []tls.Certificate{cert}
+	}
+	return c, nil
+}
+
+// headerCredentials attaches fixed metadata, such as a bearer token or an
+// API key, to every request.
+type headerCredentials struct {
+	headers map[string]string
+}
+
+// newHeaderCredentials returns the credentials selected by config, or nil
+// if there are none.
+func newHeaderCredentials(config *config.Config) *headerCredentials {
+	headers := make(map[string]string)
+	if config.Token != "" {
+		headers["authorization"] = "Bearer " + config.Token
+	}
+	if config.APIKey != "" {
+		// metadata keys must be lowercase
+		headers[strings.ToLower(config.APIKeyHeader)] = config.APIKey
+	}
+	if len(headers) == 0 {
+		return nil
+	}
+	return &headerCredentials{headers: headers}
+}
+
+func (c *headerCredentials) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	return c.headers, nil
+}
+
+// RequireTransportSecurity keeps secrets from being sent in the clear.
+func (c *headerCredentials) RequireTransportSecurity() bool {
+	return true
+}
+
+var _ credentials.PerRPCCredentials = (*headerCredentials)(nil)
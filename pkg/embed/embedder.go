@@ -0,0 +1,41 @@
+package embed
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/skrider/softgrep/pkg/config"
+	"github.com/skrider/softgrep/pkg/tokenize"
+)
+
+// backends selectable with config.Backend
+const BACKEND_TRITON = "triton"
+const BACKEND_STATIC = "static"
+
+// Embedder computes embeddings of tokenized chunks.
+type Embedder interface {
+	// Embed attaches an embedding to every chunk.
+	Embed(ctx context.Context, chunks []*tokenize.TokenizedChunk) error
+	// Check verifies that the embedder is usable before any work is started.
+	Check(ctx context.Context) error
+}
+
+// ModelID returns the name and version of the model config embeds with,
+// which identify its embeddings in caches and saved indexes.
+func ModelID(config *config.Config) (string, string, error) {
+	switch config.Backend {
+	case BACKEND_TRITON:
+		return config.Model, config.ModelVersion, nil
+	case BACKEND_STATIC:
+		if config.StaticModel == "" {
+			return BACKEND_STATIC, fmt.Sprintf("hashed-v%d-%d", HASHED_VERSION, HASHED_DIM), nil
+		}
+		digest, err := fileDigest(config.StaticModel)
+		if err != nil {
+			return "", "", err
+		}
+		return BACKEND_STATIC, digest, nil
+	default:
+		return "", "", fmt.Errorf("embed: unknown backend %q", config.Backend)
+	}
+}
@@ -0,0 +1,57 @@
+package embedtest
+
+import (
+	"net"
+	"testing"
+
+	"github.com/skrider/softgrep/pkg/config"
+	"github.com/skrider/softgrep/pkg/embed"
+)
+
+// NewConfig returns the default configuration pointed at servers: a single
+// server by host and port, several as endpoints to balance across. The
+// model is that of the first server, and the cache is in a temporary
+// directory removed when the test ends.
+func NewConfig(t testing.TB, servers ...*Server) *config.Config {
+	cfg := config.NewConfig()
+	if len(servers) == 1 {
+		cfg.Host, cfg.Port = servers[0].Host, servers[0].Port
+	} else {
+		for _, s := range servers {
+			cfg.Endpoints = append(cfg.Endpoints, net.JoinHostPort(s.Host, s.Port))
+		}
+	}
+	if len(servers) > 0 {
+		cfg.Model = servers[0].Model
+	}
+	cfg.CacheDir = t.TempDir()
+	return &cfg
+}
+
+// NewEmbedder connects to the servers cfg points at and returns an embedder
+// for cfg.Model that retries as cfg says. The connection is closed when the
+// test ends.
+func NewEmbedder(t testing.TB, cfg *config.Config) *embed.TritonEmbedder {
+	client, conn, err := embed.Connect(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	e := embed.NewTritonEmbedder(client, cfg.Model, cfg.ModelVersion)
+	e.SetRetrier(embed.NewRetrier(cfg))
+	return e
+}
+
+// NewServers starts n servers, which are stopped when the test ends.
+func NewServers(t testing.TB, n int) []*Server {
+	var servers []*Server
+	for i := 0; i < n; i++ {
+		s, err := NewServer()
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(s.Close)
+		servers = append(servers, s)
+	}
+	return servers
+}
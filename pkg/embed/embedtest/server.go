@@ -0,0 +1,324 @@
+// Package embedtest provides an in-process fake of the Triton inference
+// server for hermetic tests.
+package embedtest
+
+import (
+	"context"
+	"encoding/binary"
+	"hash/fnv"
+	"io"
+	"math"
+	"net"
+	"sync/atomic"
+
+	"github.com/skrider/softgrep/pb/triton-client"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const DEFAULT_MODEL = "codebert"
+const DEFAULT_CROSS_ENCODER = "codebert-cross"
+const DEFAULT_DIM = 256
+const SEQUENCE_LEN = 512
+
+// Server serves a fake embedding model. The embedding of a sequence is a
+// hashed set of its tokens: every distinct unmasked token adds ±1 to a
+// dimension chosen by hashing the token id, and the result is normalized.
+// Sequences sharing many tokens therefore have a high cosine similarity,
+// which is enough to make search results deterministic and meaningful.
+//
+// Server also serves a fake cross-encoder, which scores a sequence pair by
+// the cosine similarity of the embeddings of its two segments.
+type Server struct {
+	triton_client.UnimplementedGRPCInferenceServiceServer
+
+	Model        string
+	CrossEncoder string
+	Dim          int
+	// token ids that do not contribute to embeddings
+	Ignore map[int64]bool
+	// SequenceLen is the input length reported by ModelMetadata
+	SequenceLen int
+	// NotReady makes ServerReady report that the server is not ready
+	NotReady bool
+	// NoStream makes ModelStreamInfer fail with UNIMPLEMENTED, as it does on
+	// servers without streaming support
+	NoStream bool
+	// Host and Port the server is listening on
+	Host string
+	Port string
+
+	srv     *grpc.Server
+	fail    int64
+	hold    int64
+	infers  int64
+	streams int64
+}
+
+// NewServer starts a server on a random local port. The server is stopped
+// by Close.
+func NewServer(opts ...grpc.ServerOption) (*Server, error) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	host, port, err := net.SplitHostPort(lis.Addr().String())
+	if err != nil {
+		lis.Close()
+		return nil, err
+	}
+
+	s := &Server{
+		Model:        DEFAULT_MODEL,
+		CrossEncoder: DEFAULT_CROSS_ENCODER,
+		Dim:          DEFAULT_DIM,
+		// CodeBERT's <s>, <pad> and </s>, which appear in every sequence
+		Ignore:      map[int64]bool{0: true, 1: true, 2: true},
+		SequenceLen: SEQUENCE_LEN,
+		Host:        host,
+		Port:        port,
+		srv:         grpc.NewServer(opts...),
+	}
+	triton_client.RegisterGRPCInferenceServiceServer(s.srv, s)
+	go s.srv.Serve(lis)
+	return s, nil
+}
+
+func (s *Server) Close() {
+	s.srv.Stop()
+}
+
+// Fail makes the next n inference requests fail with UNAVAILABLE.
+func (s *Server) Fail(n int) {
+	atomic.StoreInt64(&s.fail, int64(n))
+}
+
+// Infers returns the number of inference requests received so far.
+func (s *Server) Infers() int {
+	return int(atomic.LoadInt64(&s.infers))
+}
+
+// Hold makes the responses to the next n streamed requests wait for the
+// next request on the same stream, which is answered first.
+func (s *Server) Hold(n int) {
+	atomic.StoreInt64(&s.hold, int64(n))
+}
+
+// Streams returns the number of inference streams currently open.
+func (s *Server) Streams() int {
+	return int(atomic.LoadInt64(&s.streams))
+}
+
+func (s *Server) ServerLive(ctx context.Context, req *triton_client.ServerLiveRequest) (*triton_client.ServerLiveResponse, error) {
+	return &triton_client.ServerLiveResponse{Live: true}, nil
+}
+
+func (s *Server) ServerReady(ctx context.Context, req *triton_client.ServerReadyRequest) (*triton_client.ServerReadyResponse, error) {
+	return &triton_client.ServerReadyResponse{Ready: !s.NotReady}, nil
+}
+
+func (s *Server) ModelReady(ctx context.Context, req *triton_client.ModelReadyRequest) (*triton_client.ModelReadyResponse, error) {
+	return &triton_client.ModelReadyResponse{Ready: req.Name == s.Model || req.Name == s.CrossEncoder}, nil
+}
+
+func (s *Server) ModelMetadata(ctx context.Context, req *triton_client.ModelMetadataRequest) (*triton_client.ModelMetadataResponse, error) {
+	if req.Name != s.Model && req.Name != s.CrossEncoder {
+		return nil, status.Errorf(codes.NotFound, "unknown model %s", req.Name)
+	}
+	output := &triton_client.ModelMetadataResponse_TensorMetadata{Name: "embeddings", Datatype: "FP32", Shape: []int64{-1, int64(s.Dim)}}
+	if req.Name == s.CrossEncoder {
+		output = &triton_client.ModelMetadataResponse_TensorMetadata{Name: "scores", Datatype: "FP32", Shape: []int64{-1, 1}}
+	}
+	input := func(name string) *triton_client.ModelMetadataResponse_TensorMetadata {
+		return &triton_client.ModelMetadataResponse_TensorMetadata{
+			Name:     name,
+			Datatype: "INT64",
+			Shape:    []int64{-1, int64(s.SequenceLen)},
+		}
+	}
+	return &triton_client.ModelMetadataResponse{
+		Name:     req.Name,
+		Versions: []string{"1"},
+		Platform: "onnxruntime_onnx",
+		Inputs: []*triton_client.ModelMetadataResponse_TensorMetadata{
+			input("input_ids"),
+			input("attention_mask"),
+			input("token_type_ids"),
+		},
+		Outputs: []*triton_client.ModelMetadataResponse_TensorMetadata{output},
+	}, nil
+}
+
+func (s *Server) ModelInfer(ctx context.Context, req *triton_client.ModelInferRequest) (*triton_client.ModelInferResponse, error) {
+	atomic.AddInt64(&s.infers, 1)
+	if atomic.AddInt64(&s.fail, -1) >= 0 {
+		return nil, status.Errorf(codes.Unavailable, "injected failure")
+	}
+	if req.ModelName != s.Model && req.ModelName != s.CrossEncoder {
+		return nil, status.Errorf(codes.NotFound, "unknown model %s", req.ModelName)
+	}
+	ids, shape, err := input(req, "input_ids")
+	if err != nil {
+		return nil, err
+	}
+	mask, _, err := input(req, "attention_mask")
+	if err != nil {
+		return nil, err
+	}
+	if len(shape) != 2 || len(mask) != len(ids) {
+		return nil, status.Errorf(codes.InvalidArgument, "unexpected input shape %v", shape)
+	}
+
+	n, width := int(shape[0]), int(shape[1])
+	if req.ModelName == s.CrossEncoder {
+		return s.score(req, ids, mask, n, width)
+	}
+	raw := make([]byte, 0, 4*n*s.Dim)
+	for row := 0; row < n; row++ {
+		v := s.embed(ids[row*width:(row+1)*width], mask[row*width:(row+1)*width])
+		for _, f := range v {
+			raw = binary.LittleEndian.AppendUint32(raw, math.Float32bits(f))
+		}
+	}
+
+	return &triton_client.ModelInferResponse{
+		ModelName:    s.Model,
+		ModelVersion: "1",
+		Id:           req.Id,
+		Outputs: []*triton_client.ModelInferResponse_InferOutputTensor{
+			{Name: "embeddings", Datatype: "FP32", Shape: []int64{int64(n), int64(s.Dim)}},
+		},
+		RawOutputContents: [][]byte{raw},
+	}, nil
+}
+
+// ModelStreamInfer answers each request on the stream as ModelInfer does.
+// Errors are reported in the response to the request that caused them, so
+// they do not end the stream.
+func (s *Server) ModelStreamInfer(stream triton_client.GRPCInferenceService_ModelStreamInferServer) error {
+	if s.NoStream {
+		return status.Error(codes.Unimplemented, "streaming inference is not supported")
+	}
+	atomic.AddInt64(&s.streams, 1)
+	defer atomic.AddInt64(&s.streams, -1)
+
+	var held []*triton_client.ModelStreamInferResponse
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		msg := &triton_client.ModelStreamInferResponse{}
+		if res, err := s.ModelInfer(stream.Context(), req); err != nil {
+			msg.ErrorMessage = err.Error()
+			msg.InferResponse = &triton_client.ModelInferResponse{Id: req.Id}
+		} else {
+			msg.InferResponse = res
+		}
+		if atomic.AddInt64(&s.hold, -1) >= 0 {
+			held = append(held, msg)
+			continue
+		}
+		for _, m := range append([]*triton_client.ModelStreamInferResponse{msg}, held...) {
+			if err := stream.Send(m); err != nil {
+				return err
+			}
+		}
+		held = nil
+	}
+}
+
+// score answers a request to the cross-encoder.
+func (s *Server) score(req *triton_client.ModelInferRequest, ids []int64, mask []int64, n int, width int) (*triton_client.ModelInferResponse, error) {
+	types, _, err := input(req, "token_type_ids")
+	if err != nil {
+		return nil, err
+	}
+	if len(types) != len(ids) {
+		return nil, status.Errorf(codes.InvalidArgument, "unexpected token_type_ids length %d", len(types))
+	}
+	raw := make([]byte, 0, 4*n)
+	for row := 0; row < n; row++ {
+		r := row * width
+		// mask out the other segment to embed each one
+		query := make([]int64, width)
+		code := make([]int64, width)
+		for i := 0; i < width; i++ {
+			if types[r+i] == 0 {
+				query[i] = mask[r+i]
+			} else {
+				code[i] = mask[r+i]
+			}
+		}
+		q := s.embed(ids[r:r+width], query)
+		c := s.embed(ids[r:r+width], code)
+		var dot float32
+		for i := range q {
+			dot += q[i] * c[i]
+		}
+		raw = binary.LittleEndian.AppendUint32(raw, math.Float32bits(dot))
+	}
+	return &triton_client.ModelInferResponse{
+		ModelName:    s.CrossEncoder,
+		ModelVersion: "1",
+		Id:           req.Id,
+		Outputs: []*triton_client.ModelInferResponse_InferOutputTensor{
+			{Name: "scores", Datatype: "FP32", Shape: []int64{int64(n), 1}},
+		},
+		RawOutputContents: [][]byte{raw},
+	}, nil
+}
+
+func (s *Server) embed(ids []int64, mask []int64) []float32 {
+	v := make([]float32, s.Dim)
+	seen := make(map[int64]bool)
+	for i, id := range ids {
+		if mask[i] == 0 || s.Ignore[id] || seen[id] {
+			continue
+		}
+		seen[id] = true
+		h := fnv.New32a()
+		binary.Write(h, binary.LittleEndian, id)
+		sum := h.Sum32()
+		if sum&1 == 0 {
+			v[(sum>>1)%uint32(s.Dim)]++
+		} else {
+			v[(sum>>1)%uint32(s.Dim)]--
+		}
+	}
+	var norm float64
+	for _, f := range v {
+		norm += float64(f * f)
+	}
+	if norm > 0 {
+		for i := range v {
+			v[i] /= float32(math.Sqrt(norm))
+		}
+	}
+	return v
+}
+
+// input returns the named INT64 input tensor of req and its shape.
+func input(req *triton_client.ModelInferRequest, name string) ([]int64, []int64, error) {
+	for i, in := range req.Inputs {
+		if in.Name != name {
+			continue
+		}
+		if in.Datatype != "INT64" {
+			return nil, nil, status.Errorf(codes.InvalidArgument, "input %s has datatype %s, expected INT64", name, in.Datatype)
+		}
+		if i < len(req.RawInputContents) {
+			raw := req.RawInputContents[i]
+			values := make([]int64, len(raw)/8)
+			for j := range values {
+				values[j] = int64(binary.LittleEndian.Uint64(raw[8*j:]))
+			}
+			return values, in.Shape, nil
+		}
+		return in.Contents.GetInt64Contents(), in.Shape, nil
+	}
+	return nil, nil, status.Errorf(codes.InvalidArgument, "missing input %s", name)
+}
@@ -0,0 +1,127 @@
+package embedtest
+
+import (
+	"context"
+	"encoding/binary"
+	"math"
+	"net"
+	"testing"
+
+	"github.com/skrider/softgrep/pb/triton-client"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+func dial(t *testing.T, s *Server) triton_client.GRPCInferenceServiceClient {
+	conn, err := grpc.Dial(net.JoinHostPort(s.Host, s.Port), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return triton_client.NewGRPCInferenceServiceClient(conn)
+}
+
+func inferRequest(model string, rows ...[]int64) *triton_client.ModelInferRequest {
+	shape := []int64{int64(len(rows)), SEQUENCE_LEN}
+	var ids, mask, types []byte
+	for _, row := range rows {
+		for i := 0; i < SEQUENCE_LEN; i++ {
+			var id, m int64
+			if i < len(row) {
+				id, m = row[i], 1
+			}
+			ids = binary.LittleEndian.AppendUint64(ids, uint64(id))
+			mask = binary.LittleEndian.AppendUint64(mask, uint64(m))
+			types = binary.LittleEndian.AppendUint64(types, 0)
+		}
+	}
+	return &triton_client.ModelInferRequest{
+		ModelName: model,
+		Inputs: []*triton_client.ModelInferRequest_InferInputTensor{
+			{Name: "input_ids", Datatype: "INT64", Shape: shape},
+			{Name: "attention_mask", Datatype: "INT64", Shape: shape},
+			{Name: "token_type_ids", Datatype: "INT64", Shape: shape},
+		},
+		RawInputContents: [][]byte{ids, mask, types},
+	}
+}
+
+func decode(t *testing.T, res *triton_client.ModelInferResponse, dim int) [][]float32 {
+	raw := res.RawOutputContents[0]
+	if len(raw)%(4*dim) != 0 {
+		t.Fatalf("expected a multiple of %d bytes, got %d", 4*dim, len(raw))
+	}
+	var rows [][]float32
+	for off := 0; off < len(raw); off += 4 * dim {
+		row := make([]float32, dim)
+		for i := range row {
+			row[i] = math.Float32frombits(binary.LittleEndian.Uint32(raw[off+4*i:]))
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+func cosine(a []float32, b []float32) float32 {
+	var sum float32
+	for i := range a {
+		sum += a[i] * b[i]
+	}
+	return sum
+}
+
+func TestServerHealth(t *testing.T) {
+	s, err := NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	client := dial(t, s)
+	ctx := context.Background()
+
+	live, err := client.ServerLive(ctx, &triton_client.ServerLiveRequest{})
+	if err != nil || !live.Live {
+		t.Fatalf("expected server to be live: %v %v", live, err)
+	}
+	ready, err := client.ModelReady(ctx, &triton_client.ModelReadyRequest{Name: s.Model})
+	if err != nil || !ready.Ready {
+		t.Fatalf("expected model to be ready: %v %v", ready, err)
+	}
+	meta, err := client.ModelMetadata(ctx, &triton_client.ModelMetadataRequest{Name: s.Model})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(meta.Inputs) != 3 || meta.Outputs[0].Shape[1] != int64(s.Dim) {
+		t.Fatalf("unexpected metadata: %v", meta)
+	}
+	if _, err := client.ModelMetadata(ctx, &triton_client.ModelMetadataRequest{Name: "missing"}); err == nil {
+		t.Fatal("expected an error for an unknown model")
+	}
+}
+
+func TestServerInfer(t *testing.T) {
+	s, err := NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	client := dial(t, s)
+
+	a := []int64{0, 11, 12, 13, 14, 15, 2}
+	b := []int64{0, 11, 12, 13, 14, 99, 2}
+	c := []int64{0, 51, 52, 53, 54, 55, 2}
+	res, err := client.ModelInfer(context.Background(), inferRequest(s.Model, a, b, c, a))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows := decode(t, res, s.Dim)
+	if len(rows) != 4 {
+		t.Fatalf("expected 4 embeddings, got %d", len(rows))
+	}
+	if sim := cosine(rows[0], rows[3]); math.Abs(float64(sim)-1) > 1e-5 {
+		t.Errorf("expected identical inputs to have identical embeddings, similarity %f", sim)
+	}
+	if cosine(rows[0], rows[1]) <= cosine(rows[0], rows[2]) {
+		t.Errorf("expected overlapping inputs to be more similar than disjoint ones")
+	}
+}
@@ -1,21 +1,126 @@
 package embed
 
 import (
+	"errors"
 	"fmt"
+	"io"
+	"net"
+	"strings"
 
 	"github.com/skrider/softgrep/pb/triton-client"
+	"github.com/skrider/softgrep/pkg/config"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/keepalive"
 )
 
-func NewClient(host string, port string) (triton_client.GRPCInferenceServiceClient, error) {
+// NewClient connects to the server at host:port without TLS. The extra
+// options are applied last, so the transport credentials from DialOptions
+// take precedence.
+func NewClient(host string, port string, extra ...grpc.DialOption) (triton_client.GRPCInferenceServiceClient, error) {
+	conn, err := dial(host, port, extra...)
+	if err != nil {
+		return nil, err
+	}
+
+	return triton_client.NewGRPCInferenceServiceClient(conn), nil
+}
+
+func dial(host string, port string, extra ...grpc.DialOption) (*grpc.ClientConn, error) {
 	var opts []grpc.DialOption
 	opts = append(opts, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	opts = append(opts, extra...)
 
-	conn, err := grpc.Dial(fmt.Sprintf("%s:%s", host, port), opts...)
+	return grpc.Dial(fmt.Sprintf("%s:%s", host, port), opts...)
+}
+
+const DNS_SCHEME = "dns:///"
+
+// Address is one inference server. Authority, when set, is the name the
+// server is known by, for TLS verification and the :authority header.
+type Address struct {
+	Target    string
+	Authority string
+}
+
+// ResolveEndpoints turns each endpoint into addresses. An endpoint of the
+// form dns:///name:port is looked up and yields one address per IP it
+// resolves to, anything else is used as is.
+func ResolveEndpoints(endpoints []string) ([]Address, error) {
+	var addrs []Address
+	for _, endpoint := range endpoints {
+		if !strings.HasPrefix(endpoint, DNS_SCHEME) {
+			addrs = append(addrs, Address{Target: endpoint})
+			continue
+		}
+		name := strings.TrimPrefix(endpoint, DNS_SCHEME)
+		host, port, err := net.SplitHostPort(name)
+		if err != nil {
+			return nil, fmt.Errorf("embed: endpoint %s: %w", endpoint, err)
+		}
+		ips, err := net.LookupHost(host)
+		if err != nil {
+			return nil, fmt.Errorf("embed: resolving endpoint %s: %w", endpoint, err)
+		}
+		for _, ip := range ips {
+			addrs = append(addrs, Address{Target: net.JoinHostPort(ip, port), Authority: name})
+		}
+	}
+	return addrs, nil
+}
+
+// Connect returns a client for the inference servers selected by config:
+// a plain client for --host and --port, or a Balancer over the endpoints
+// when config.Endpoints is set. Closing the returned io.Closer releases the
+// connections.
+func Connect(config *config.Config) (triton_client.GRPCInferenceServiceClient, io.Closer, error) {
+	opts, err := DialOptions(config)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+	if len(config.Endpoints) == 0 {
+		conn, err := dial(config.Host, config.Port, opts...)
+		if err != nil {
+			return nil, nil, err
+		}
+		return triton_client.NewGRPCInferenceServiceClient(conn), conn, nil
+	}
+	addrs, err := ResolveEndpoints(config.Endpoints)
+	if err != nil {
+		return nil, nil, err
+	}
+	balancer, err := NewBalancer(addrs, config.Balance, config.HealthInterval, opts...)
+	if err != nil {
+		return nil, nil, err
 	}
+	return balancer, balancer, nil
+}
 
-	return triton_client.NewGRPCInferenceServiceClient(conn), nil
+// DialOptions returns the connection settings selected by config.
+func DialOptions(config *config.Config) ([]grpc.DialOption, error) {
+	var opts []grpc.DialOption
+	if tlsEnabled(config) {
+		c, err := tlsConfig(config)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, grpc.WithTransportCredentials(credentials.NewTLS(c)))
+	}
+	if creds := newHeaderCredentials(config); creds != nil {
+		if !tlsEnabled(config) {
+			return nil, errors.New("embed: a token or API key is only sent over TLS, see --tls")
+		}
+		opts = append(opts, grpc.WithPerRPCCredentials(creds))
+	}
+	if config.KeepaliveTime > 0 {
+		// pings let a connection to a server that went away fail fast
+		// instead of hanging until the request deadline
+		opts = append(opts, grpc.WithKeepaliveParams(keepalive.ClientParameters{
+			Time:                config.KeepaliveTime,
+			Timeout:             config.KeepaliveTimeout,
+			PermitWithoutStream: true,
+		}))
+	}
+	return opts, nil
 }
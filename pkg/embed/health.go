@@ -0,0 +1,113 @@
+package embed
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/skrider/softgrep/pb/triton-client"
+	"github.com/skrider/softgrep/pkg/tokenize"
+)
+
+// CHECK_TIMEOUT bounds how long Check waits for the server to respond.
+const CHECK_TIMEOUT = 10 * time.Second
+
+// Check verifies that the server is live and ready, that the model is
+// loaded, and that the model's inputs and outputs match the requests Embed
+// sends. Running it before any work is started turns a misconfigured server
+// into one clear error instead of a failed batch midway through a run.
+// Behind a Balancer, every ready server is checked.
+func (e *TritonEmbedder) Check(ctx context.Context) error {
+	return checkEach(e.client, func(client triton_client.GRPCInferenceServiceClient) error {
+		metadata, err := checkModel(ctx, client, e.model, e.getVersion())
+		if err != nil {
+			return err
+		}
+		return checkMetadata(metadata, EMBEDDINGS, -1)
+	})
+}
+
+// checkEach runs check against client, or against each server behind it
+// if it is a Balancer.
+func checkEach(client triton_client.GRPCInferenceServiceClient, check func(triton_client.GRPCInferenceServiceClient) error) error {
+	if b, ok := client.(*Balancer); ok {
+		return b.Check(check)
+	}
+	return check(client)
+}
+
+// checkModel checks that the server is live and ready and that the model
+// is loaded, and returns the model's metadata.
+func checkModel(ctx context.Context, client triton_client.GRPCInferenceServiceClient, model string, version string) (*triton_client.ModelMetadataResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, CHECK_TIMEOUT)
+	defer cancel()
+
+	live, err := client.ServerLive(ctx, &triton_client.ServerLiveRequest{})
+	if err != nil {
+		return nil, fmt.Errorf("embed: server is unreachable: %w", err)
+	}
+	if !live.Live {
+		return nil, fmt.Errorf("embed: server is not live")
+	}
+	ready, err := client.ServerReady(ctx, &triton_client.ServerReadyRequest{})
+	if err != nil {
+		return nil, fmt.Errorf("embed: checking server readiness: %w", err)
+	}
+	if !ready.Ready {
+		return nil, fmt.Errorf("embed: server is not ready")
+	}
+
+	modelReady, err := client.ModelReady(ctx, &triton_client.ModelReadyRequest{Name: model, Version: version})
+	if err != nil {
+		return nil, fmt.Errorf("embed: checking readiness of model %s: %w", model, err)
+	}
+	if !modelReady.Ready {
+		return nil, fmt.Errorf("embed: model %s version %q is not loaded or not ready", model, version)
+	}
+
+	metadata, err := client.ModelMetadata(ctx, &triton_client.ModelMetadataRequest{Name: model, Version: version})
+	if err != nil {
+		return nil, fmt.Errorf("embed: fetching metadata of model %s: %w", model, err)
+	}
+	return metadata, nil
+}
+
+// checkMetadata checks that the model takes [batch, MAX_LEN] INT64 inputs
+// and produces a [batch, dim] FP32 output, of any width if dim is -1.
+// Dimensions the model leaves variable are reported as -1.
+func checkMetadata(metadata *triton_client.ModelMetadataResponse, output string, dim int64) error {
+	tensors := make(map[string]*triton_client.ModelMetadataResponse_TensorMetadata)
+	for _, t := range metadata.Inputs {
+		tensors[t.Name] = t
+	}
+	for _, name := range []string{INPUT_IDS, ATTENTION_MASK, TOKEN_TYPE_IDS} {
+		t, ok := tensors[name]
+		if !ok {
+			return fmt.Errorf("embed: model %s has no input %s", metadata.Name, name)
+		}
+		if t.Datatype != INT64 {
+			return fmt.Errorf("embed: model %s input %s has datatype %s, expected %s", metadata.Name, name, t.Datatype, INT64)
+		}
+		if len(t.Shape) != 2 || t.Shape[0] != -1 || (t.Shape[1] != -1 && t.Shape[1] != tokenize.MAX_LEN) {
+			return fmt.Errorf("embed: model %s input %s has shape %v, expected [-1 %d]", metadata.Name, name, t.Shape, tokenize.MAX_LEN)
+		}
+	}
+
+	for _, t := range metadata.Outputs {
+		if t.Name != output {
+			continue
+		}
+		if t.Datatype != FP32 {
+			return fmt.Errorf("embed: model %s output %s has datatype %s, expected %s", metadata.Name, t.Name, t.Datatype, FP32)
+		}
+		if len(t.Shape) != 2 || t.Shape[0] != -1 || (dim != -1 && t.Shape[1] != dim) {
+			expected := "[-1 dim]"
+			if dim != -1 {
+				expected = fmt.Sprintf("[-1 %d]", dim)
+			}
+			return fmt.Errorf("embed: model %s output %s has shape %v, expected %s", metadata.Name, t.Name, t.Shape, expected)
+		}
+		return nil
+	}
+	return fmt.Errorf("embed: model %s has no output %s", metadata.Name, output)
+}
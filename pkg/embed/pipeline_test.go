@@ -0,0 +1,183 @@
+package embed_test
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/skrider/softgrep/pkg/chunk"
+	"github.com/skrider/softgrep/pkg/config"
+	"github.com/skrider/softgrep/pkg/embed"
+	"github.com/skrider/softgrep/pkg/embed/embedtest"
+	"github.com/skrider/softgrep/pkg/index"
+	"github.com/skrider/softgrep/pkg/tokenize"
+	"github.com/skrider/softgrep/pkg/walker"
+)
+
+const TESTDATA = "../../testdata/search"
+
+// tokenizeTree walks root and returns the tokenized chunks of every file.
+func tokenizeTree(t *testing.T, cfg *config.Config, root string) []*tokenize.TokenizedChunk {
+	var chunks []*tokenize.TokenizedChunk
+	w := walker.NewWalker(func(path string, file *os.File) error {
+		defer file.Close()
+		chunker, err := chunk.NewChunker(path, file, cfg, tokenize.TokenEnds)
+		if err != nil {
+			return err
+		}
+		for c, err := chunker.Next(); err != io.EOF; c, err = chunker.Next() {
+			if err != nil {
+				return err
+			}
+			tok := tokenize.NewTokenizer(c)
+			for tc := tok.Next(); tc != nil; tc = tok.Next() {
+				chunks = append(chunks, tc)
+			}
+		}
+		return nil
+	})
+	if err := w.Walk(root); err != nil {
+		t.Fatal(err)
+	}
+	if len(chunks) == 0 {
+		t.Fatalf("no chunks found in %s", root)
+	}
+	return chunks
+}
+
+func search(t *testing.T, e *embed.TritonEmbedder, idx index.Index, query string) []index.Result {
+	q := tokenize.NewTokenizer(&chunk.Chunk{Content: query}).Next()
+	if err := e.Embed(context.Background(), []*tokenize.TokenizedChunk{q}); err != nil {
+		t.Fatal(err)
+	}
+	return idx.Search(q.Embedding, 3)
+}
+
+// embedOne embeds a single small chunk.
+func embedOne(e *embed.TritonEmbedder) error {
+	c := tokenize.NewTokenizer(&chunk.Chunk{Content: "func main() {}"}).Next()
+	return e.Embed(context.Background(), []*tokenize.TokenizedChunk{c})
+}
+
+func TestPipeline(t *testing.T) {
+	s, err := embedtest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	cfg := embedtest.NewConfig(t, s)
+	e := embedtest.NewEmbedder(t, cfg)
+
+	chunks := tokenizeTree(t, cfg, TESTDATA)
+	if err := e.Embed(context.Background(), chunks); err != nil {
+		t.Fatal(err)
+	}
+	idx := index.NewFlat()
+	for _, c := range chunks {
+		if len(c.Embedding) != s.Dim {
+			t.Fatalf("expected a %d-dim embedding, got %d", s.Dim, len(c.Embedding))
+		}
+		if err := idx.Add(c.Embedding, *c.Chunk); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cases := []struct {
+		query string
+		file  string
+		text  string
+	}{
+		{"def fibonacci(n)", "sequences.py", "def fibonacci"},
+		{"func IsPalindrome(s string) bool", "strings.go", "func IsPalindrome"},
+		{"kubectl rollout restart deployment", "deploy.sh", "restart_service"},
+	}
+	for _, c := range cases {
+		results := search(t, e, idx, c.query)
+		if len(results) == 0 {
+			t.Fatalf("%q: no results", c.query)
+		}
+		top := results[0]
+		if filepath.Base(top.Path) != c.file || !strings.Contains(top.Content, c.text) {
+			t.Errorf("%q: expected %s in %s, got %s:%d %q", c.query, c.text, c.file, top.Path, top.Start.Row+1, top.Content)
+		}
+		if top.Language == "" || top.Query == "" {
+			t.Errorf("%q: expected the top result to come from a tree-sitter query, got %+v", c.query, top.Chunk)
+		}
+	}
+}
+
+// TestBatchedStream runs chunks through the batcher with a streaming
+// embedder, with and without streaming support on the server.
+func TestBatchedStream(t *testing.T) {
+	for _, noStream := range []bool{false, true} {
+		s, err := embedtest.NewServer()
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.NoStream = noStream
+
+		cfg := embedtest.NewConfig(t, s)
+		streamer := embed.NewStreamEmbedder(embedtest.NewEmbedder(t, cfg), 2)
+		batcher := embed.NewBatcher(streamer, 2, 10*time.Millisecond, 2)
+
+		chunks := tokenizeTree(t, cfg, TESTDATA)
+		in := make(chan *tokenize.TokenizedChunk, len(chunks))
+		out := make(chan *tokenize.TokenizedChunk, len(chunks))
+		for _, c := range chunks {
+			in <- c
+		}
+		close(in)
+		if err := batcher.Run(context.Background(), in, out); err != nil {
+			t.Fatalf("no stream %t: %v", noStream, err)
+		}
+		close(out)
+
+		n := 0
+		for c := range out {
+			if len(c.Embedding) != s.Dim {
+				t.Fatalf("no stream %t: expected a %d-dim embedding, got %d", noStream, s.Dim, len(c.Embedding))
+			}
+			n++
+		}
+		if n != len(chunks) {
+			t.Fatalf("no stream %t: expected %d embedded chunks, got %d", noStream, len(chunks), n)
+		}
+		streamer.Close()
+		s.Close()
+	}
+}
+
+func TestCheck(t *testing.T) {
+	cases := []struct {
+		name   string
+		modify func(s *embedtest.Server)
+		model  string
+		err    string
+	}{
+		{"healthy", func(s *embedtest.Server) {}, embedtest.DEFAULT_MODEL, ""},
+		{"not ready", func(s *embedtest.Server) { s.NotReady = true }, embedtest.DEFAULT_MODEL, "server is not ready"},
+		{"unknown model", func(s *embedtest.Server) {}, "unixcoder", "model unixcoder version \"\" is not loaded"},
+		{"sequence length", func(s *embedtest.Server) { s.SequenceLen = 256 }, embedtest.DEFAULT_MODEL, "has shape [-1 256], expected [-1 512]"},
+	}
+	for _, c := range cases {
+		s, err := embedtest.NewServer()
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.modify(s)
+		cfg := embedtest.NewConfig(t, s)
+		cfg.Model = c.model
+		err = embedtest.NewEmbedder(t, cfg).Check(context.Background())
+		if c.err == "" && err != nil {
+			t.Errorf("%s: unexpected error %v", c.name, err)
+		} else if c.err != "" && (err == nil || !strings.Contains(err.Error(), c.err)) {
+			t.Errorf("%s: expected an error containing %q, got %v", c.name, c.err, err)
+		}
+		s.Close()
+	}
+}
@@ -0,0 +1,77 @@
+package embed
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/skrider/softgrep/pb/triton-client"
+	"github.com/skrider/softgrep/pkg/tokenize"
+)
+
+// SCORES is the output of a cross-encoder model, one relevance score per
+// sequence pair.
+const SCORES = "scores"
+
+// CrossEncoder scores (query, code) sequence pairs, see
+// tokenize.EncodePair, with a model on the inference server that reads both
+// sequences at once. It is too slow to run over a whole index but ranks
+// better than comparing embeddings, so it is used to re-rank the best
+// candidates of a search.
+type CrossEncoder struct {
+	client  triton_client.GRPCInferenceServiceClient
+	model   string
+	version string
+	retrier *Retrier
+}
+
+// CrossEncoder returns a CrossEncoder for model on the server e sends
+// requests to, retrying like e.
+func (e *TritonEmbedder) CrossEncoder(model string, version string) *CrossEncoder {
+	return &CrossEncoder{
+		client:  e.client,
+		model:   model,
+		version: version,
+		retrier: e.retrier,
+	}
+}
+
+// Score returns the score of each pair, higher meaning more relevant.
+func (e *CrossEncoder) Score(ctx context.Context, pairs []*tokenize.TokenizedChunk) ([]float32, error) {
+	if len(pairs) == 0 {
+		return nil, nil
+	}
+	req := newInferRequest(e.model, e.version, SCORES, pairs)
+	var res *triton_client.ModelInferResponse
+	err := e.retrier.Do(ctx, func(ctx context.Context) error {
+		var err error
+		res, err = e.client.ModelInfer(ctx, req)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	rows, err := parseOutput(res, SCORES, len(pairs))
+	if err != nil {
+		return nil, err
+	}
+	scores := make([]float32, len(rows))
+	for i, row := range rows {
+		if len(row) != 1 {
+			return nil, fmt.Errorf("embed: expected one score per pair, got %d", len(row))
+		}
+		scores[i] = row[0]
+	}
+	return scores, nil
+}
+
+// Check verifies that the cross-encoder model is loaded and takes and
+// returns what Score expects, on every ready server behind a Balancer.
+func (e *CrossEncoder) Check(ctx context.Context) error {
+	return checkEach(e.client, func(client triton_client.GRPCInferenceServiceClient) error {
+		metadata, err := checkModel(ctx, client, e.model, e.version)
+		if err != nil {
+			return err
+		}
+		return checkMetadata(metadata, SCORES, 1)
+	})
+}
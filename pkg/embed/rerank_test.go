@@ -0,0 +1,42 @@
+package embed_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/skrider/softgrep/pkg/chunk"
+	"github.com/skrider/softgrep/pkg/embed/embedtest"
+	"github.com/skrider/softgrep/pkg/tokenize"
+)
+
+func TestCrossEncoder(t *testing.T) {
+	s, err := embedtest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	e := embedtest.NewEmbedder(t, embedtest.NewConfig(t, s))
+	ctx := context.Background()
+	if err := e.CrossEncoder(s.Model, "").Check(ctx); err == nil || !strings.Contains(err.Error(), "has no output scores") {
+		t.Errorf("expected the embedding model to be rejected, got %v", err)
+	}
+	ce := e.CrossEncoder(s.CrossEncoder, "")
+	if err := ce.Check(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	query := "def fibonacci(n)"
+	pairs := []*tokenize.TokenizedChunk{
+		tokenize.EncodePair(query, &chunk.Chunk{Content: "kubectl rollout restart deployment"}),
+		tokenize.EncodePair(query, &chunk.Chunk{Content: "def fibonacci(n):\n    return n"}),
+	}
+	scores, err := ce.Score(ctx, pairs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(scores) != 2 || scores[1] <= scores[0] {
+		t.Errorf("expected the matching pair to score higher, got %v", scores)
+	}
+}
@@ -0,0 +1,211 @@
+package embed
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/skrider/softgrep/pkg/config"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Retrier retries inference requests that fail with a transient error,
+// waiting an exponentially growing, jittered delay between attempts. Each
+// attempt gets its own deadline. A Retrier is shared by every request to
+// one server, so its circuit breaker sees all of their failures. While the
+// breaker is open, requests wait for it without using up attempts.
+type Retrier struct {
+	attempts   int
+	timeout    time.Duration
+	backoff    time.Duration
+	maxBackoff time.Duration
+	breaker    *breaker
+}
+
+func NewRetrier(config *config.Config) *Retrier {
+	attempts := config.Retries + 1
+	if attempts < 1 {
+		attempts = 1
+	}
+	return &Retrier{
+		attempts:   attempts,
+		timeout:    config.RequestTimeout,
+		backoff:    config.RetryBackoff,
+		maxBackoff: config.RetryMaxBackoff,
+		breaker:    newBreaker(config.BreakerThreshold, config.BreakerCooldown),
+	}
+}
+
+// retryable reports whether an attempt that failed with err may succeed if
+// tried again. parent is the context of the whole request, which tells a
+// per-attempt timeout apart from the caller giving up.
+func retryable(parent context.Context, err error) bool {
+	if parent.Err() != nil {
+		return false
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	switch status.Code(err) {
+	case codes.Unavailable, codes.ResourceExhausted, codes.DeadlineExceeded:
+		return true
+	}
+	return false
+}
+
+// delay returns the wait before retry number attempt, counting from 0: a
+// uniformly random duration up to backoff * 2^attempt, capped at
+// maxBackoff.
+func (r *Retrier) delay(attempt int) time.Duration {
+	d := r.maxBackoff
+	if attempt < 32 && r.backoff<<attempt < d && r.backoff<<attempt > 0 {
+		d = r.backoff << attempt
+	}
+	if d <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(d)) + 1)
+}
+
+// Do calls fn until it succeeds, fails with an error that is not
+// transient, or runs out of attempts. Time spent waiting for the circuit
+// breaker is bounded only by ctx.
+func (r *Retrier) Do(ctx context.Context, fn func(ctx context.Context) error) error {
+	if r == nil {
+		return fn(ctx)
+	}
+	var err error
+	for attempt := 0; attempt < r.attempts; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-time.After(r.delay(attempt - 1)):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+
+		if err := r.breaker.wait(ctx); err != nil {
+			return err
+		}
+		attemptCtx, cancel := ctx, context.CancelFunc(func() {})
+		if r.timeout > 0 {
+			attemptCtx, cancel = context.WithTimeout(ctx, r.timeout)
+		}
+		err = fn(attemptCtx)
+		cancel()
+
+		if err == nil {
+			r.breaker.record(true)
+			return nil
+		}
+		if !retryable(ctx, err) {
+			if ctx.Err() != nil {
+				r.breaker.abandon()
+			} else {
+				// the server answered, so it is up
+				r.breaker.record(true)
+			}
+			return err
+		}
+		r.breaker.record(false)
+	}
+	return fmt.Errorf("giving up after %d attempts: %w", r.attempts, err)
+}
+
+// breaker holds back requests to a server after threshold consecutive
+// transient failures. Once cooldown has passed a single request is let
+// through as a probe; the breaker closes again if it succeeds.
+type breaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+	probing   bool
+	changed   chan struct{} // closed when a request ends
+}
+
+func newBreaker(threshold int, cooldown time.Duration) *breaker {
+	return &breaker{threshold: threshold, cooldown: cooldown, changed: make(chan struct{})}
+}
+
+// wait returns once the breaker lets a request through, or with ctx's error
+// if ctx is done first.
+func (b *breaker) wait(ctx context.Context) error {
+	if b.threshold <= 0 {
+		return nil
+	}
+	for {
+		b.mu.Lock()
+		if b.failures < b.threshold {
+			b.mu.Unlock()
+			return nil
+		}
+		cooldown, probing := time.Until(b.openUntil), b.probing
+		if !probing && cooldown <= 0 {
+			b.probing = true
+			b.mu.Unlock()
+			return nil
+		}
+		changed := b.changed
+		b.mu.Unlock()
+
+		// wait for the cooldown to end, or for the probe to finish
+		var timer *time.Timer
+		var expired <-chan time.Time
+		if !probing {
+			timer = time.NewTimer(cooldown)
+			expired = timer.C
+		}
+		select {
+		case <-expired:
+		case <-changed:
+		case <-ctx.Done():
+			if timer != nil {
+				timer.Stop()
+			}
+			return ctx.Err()
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
+
+// notify wakes the requests waiting for the breaker. b.mu must be held.
+func (b *breaker) notify() {
+	close(b.changed)
+	b.changed = make(chan struct{})
+}
+
+func (b *breaker) record(ok bool) {
+	if b.threshold <= 0 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+	defer b.notify()
+	if ok {
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.failures >= b.threshold {
+		b.openUntil = time.Now().Add(b.cooldown)
+	}
+}
+
+// abandon ends a request that says nothing about the server's health, such
+// as one cancelled by the caller.
+func (b *breaker) abandon() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+	b.notify()
+}
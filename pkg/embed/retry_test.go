@@ -0,0 +1,110 @@
+package embed_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/skrider/softgrep/pkg/chunk"
+	"github.com/skrider/softgrep/pkg/embed/embedtest"
+	"github.com/skrider/softgrep/pkg/tokenize"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestRetry(t *testing.T) {
+	s, err := embedtest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	cfg := embedtest.NewConfig(t, s)
+	cfg.Retries = 3
+	cfg.RetryBackoff = time.Millisecond
+	cfg.KeepaliveTime = time.Minute
+	e := embedtest.NewEmbedder(t, cfg)
+
+	s.Fail(3)
+	if err := embedOne(e); err != nil {
+		t.Fatalf("expected the fourth attempt to succeed, got %v", err)
+	}
+	if n := s.Infers(); n != 4 {
+		t.Errorf("expected 4 requests, got %d", n)
+	}
+
+	s.Fail(4)
+	if err := embedOne(e); status.Code(errors.Unwrap(err)) != codes.Unavailable {
+		t.Errorf("expected UNAVAILABLE once retries ran out, got %v", err)
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	s, err := embedtest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	cfg := embedtest.NewConfig(t, s)
+	cfg.Retries = 1
+	cfg.RetryBackoff = time.Millisecond
+	cfg.BreakerThreshold = 2
+	cfg.BreakerCooldown = 50 * time.Millisecond
+	e := embedtest.NewEmbedder(t, cfg)
+
+	s.Fail(2)
+	if err := embedOne(e); status.Code(errors.Unwrap(err)) != codes.Unavailable {
+		t.Fatalf("expected UNAVAILABLE once retries ran out, got %v", err)
+	}
+
+	// the breaker is open, so a request waits for the cooldown instead of
+	// reaching the server, and gives up with its context
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.BreakerCooldown/5)
+	defer cancel()
+	c := tokenize.NewTokenizer(&chunk.Chunk{Content: "func main() {}"}).Next()
+	if err := e.Embed(ctx, []*tokenize.TokenizedChunk{c}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the request to wait for the breaker until its deadline, got %v", err)
+	}
+	if n := s.Infers(); n != 2 {
+		t.Errorf("expected the breaker to stop requests after 2, got %d", n)
+	}
+
+	// once the cooldown has passed a probe is let through, and its success
+	// closes the breaker
+	if err := embedOne(e); err != nil {
+		t.Fatalf("expected the breaker to close again, got %v", err)
+	}
+}
+
+func TestCircuitBreakerOutage(t *testing.T) {
+	s, err := embedtest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	cfg := embedtest.NewConfig(t, s)
+	cfg.Retries = 3
+	cfg.RetryBackoff = time.Millisecond
+	cfg.RetryMaxBackoff = 5 * time.Millisecond
+	cfg.BreakerThreshold = 2
+	cfg.BreakerCooldown = 50 * time.Millisecond
+	e := embedtest.NewEmbedder(t, cfg)
+
+	// the server is down for longer than the retries alone could wait:
+	// two failures open the breaker, the first probe fails and reopens it,
+	// and the second probe succeeds on the last attempt
+	s.Fail(3)
+	start := time.Now()
+	if err := embedOne(e); err != nil {
+		t.Fatalf("expected the request to outlast the outage, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 2*cfg.BreakerCooldown {
+		t.Errorf("expected the request to wait out two cooldowns, took %s", elapsed)
+	}
+	if n := s.Infers(); n != 4 {
+		t.Errorf("expected 4 requests, got %d", n)
+	}
+}
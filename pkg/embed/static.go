@@ -0,0 +1,209 @@
+package embed
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+
+	"github.com/skrider/softgrep/pkg/tokenize"
+)
+
+// HASHED_DIM is the dimension of embeddings from the built in hashed model.
+const HASHED_DIM = 256
+
+// HASHED_VERSION is bumped whenever the hashed model embeds the same tokens
+// differently, so that its cached embeddings are not reused.
+const HASHED_VERSION = 2
+
+// name of the token embedding tensor in a static model file, as written by
+// model2vec
+const STATIC_TENSOR = "embeddings"
+
+// StaticEmbedder embeds a chunk as the normalized mean of a fixed vector per
+// token, which takes no server and little CPU. Without a model file each
+// token's vector is derived from a hash of its id, so chunks sharing more
+// tokens score higher. A model file distilled for the tokenizer's vocabulary
+// gives vectors that also capture meaning.
+type StaticEmbedder struct {
+	vectors []float32 // rows of dim floats, nil for the hashed model
+	dim     int
+}
+
+// NewStaticEmbedder loads the token embeddings in path, a safetensors file
+// with a float32 [vocab, dim] tensor named embeddings. An empty path selects
+// the hashed model.
+func NewStaticEmbedder(path string) (*StaticEmbedder, error) {
+	if path == "" {
+		return &StaticEmbedder{dim: HASHED_DIM}, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	vectors, dim, err := readSafetensors(f, STATIC_TENSOR)
+	if err != nil {
+		return nil, fmt.Errorf("embed: reading static model %s: %w", path, err)
+	}
+	return &StaticEmbedder{vectors: vectors, dim: dim}, nil
+}
+
+// Check verifies that a model file has a vector for every token of the
+// tokenizer's vocabulary. The hashed model has a vector for any token.
+func (e *StaticEmbedder) Check(ctx context.Context) error {
+	if e.vectors == nil {
+		return nil
+	}
+	if rows, vocab := len(e.vectors)/e.dim, tokenize.VocabSize(); rows < vocab {
+		return fmt.Errorf("embed: static model has %d token vectors, but the tokenizer's vocabulary has %d tokens", rows, vocab)
+	}
+	return nil
+}
+
+func (e *StaticEmbedder) Embed(ctx context.Context, chunks []*tokenize.TokenizedChunk) error {
+	for _, c := range chunks {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		v, err := e.embed(c)
+		if err != nil {
+			return err
+		}
+		c.Embedding = v
+	}
+	return nil
+}
+
+func (e *StaticEmbedder) embed(c *tokenize.TokenizedChunk) ([]float32, error) {
+	sum := make([]float32, e.dim)
+	seen := make(map[uint32]bool)
+	for i, token := range c.Tokens {
+		if c.InputMask[i] == 0 || token == tokenize.CLS_TOKEN_ID || token == tokenize.SEP_TOKEN_ID {
+			continue
+		}
+		if e.vectors == nil {
+			// hashed vectors carry no notion of how common a token is, so
+			// count each token once to keep whitespace and punctuation
+			// from drowning out the rest
+			if !seen[token] {
+				seen[token] = true
+				addHashed(sum, token)
+			}
+			continue
+		}
+		row := int(token) * e.dim
+		if row+e.dim > len(e.vectors) {
+			return nil, fmt.Errorf("embed: token %d is not in the static model's vocabulary of %d", token, len(e.vectors)/e.dim)
+		}
+		for j, x := range e.vectors[row : row+e.dim] {
+			sum[j] += x
+		}
+	}
+	normalize(sum)
+	return sum, nil
+}
+
+// addHashed adds the pseudo-random unit-variance vector of token to sum.
+// The vectors of distinct tokens are nearly orthogonal.
+func addHashed(sum []float32, token uint32) {
+	state := uint64(token)
+	for i := range sum {
+		// splitmix64
+		state += 0x9e3779b97f4a7c15
+		z := state
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		z ^= z >> 31
+		if z&1 == 0 {
+			sum[i]++
+		} else {
+			sum[i]--
+		}
+	}
+}
+
+// normalize scales v to unit length, so dot products are cosine similarity.
+// A zero vector is left as is.
+func normalize(v []float32) {
+	var norm float64
+	for _, x := range v {
+		norm += float64(x) * float64(x)
+	}
+	if norm == 0 {
+		return
+	}
+	scale := float32(1 / math.Sqrt(norm))
+	for i := range v {
+		v[i] *= scale
+	}
+}
+
+type safetensorsEntry struct {
+	Dtype       string   `json:"dtype"`
+	Shape       []int    `json:"shape"`
+	DataOffsets [2]int64 `json:"data_offsets"`
+}
+
+// readSafetensors returns the 2-D float32 tensor called name in the
+// safetensors file read by r, flattened, along with its second dimension.
+// See https://github.com/huggingface/safetensors for the format.
+func readSafetensors(r io.Reader, name string) ([]float32, int, error) {
+	var headerLen uint64
+	if err := binary.Read(r, binary.LittleEndian, &headerLen); err != nil {
+		return nil, 0, err
+	}
+	if headerLen > 100<<20 {
+		return nil, 0, fmt.Errorf("header of %d bytes is too large", headerLen)
+	}
+	header := make([]byte, headerLen)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, 0, err
+	}
+	entries := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(header, &entries); err != nil {
+		return nil, 0, fmt.Errorf("parsing header: %w", err)
+	}
+	raw, ok := entries[name]
+	if !ok {
+		return nil, 0, fmt.Errorf("no tensor named %s", name)
+	}
+	var entry safetensorsEntry
+	if err := json.Unmarshal(raw, &entry); err != nil {
+		return nil, 0, fmt.Errorf("parsing tensor %s: %w", name, err)
+	}
+	if entry.Dtype != "F32" || len(entry.Shape) != 2 || entry.Shape[0] <= 0 || entry.Shape[1] <= 0 {
+		return nil, 0, fmt.Errorf("tensor %s is %s %v, expected a 2-D F32 tensor", name, entry.Dtype, entry.Shape)
+	}
+	start, end := entry.DataOffsets[0], entry.DataOffsets[1]
+	if end-start != int64(entry.Shape[0])*int64(entry.Shape[1])*4 {
+		return nil, 0, fmt.Errorf("tensor %s has %d bytes of data, expected %v float32s", name, end-start, entry.Shape)
+	}
+	if _, err := io.CopyN(io.Discard, r, start); err != nil {
+		return nil, 0, err
+	}
+	data := make([]byte, end-start)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, 0, err
+	}
+	return decodeFloat32(data), entry.Shape[1], nil
+}
+
+// fileDigest returns a short hash of the contents of path.
+func fileDigest(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)[:8]), nil
+}
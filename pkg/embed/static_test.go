@@ -0,0 +1,117 @@
+package embed
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/skrider/softgrep/pkg/tokenize"
+)
+
+func newChunk(tokens ...uint32) *tokenize.TokenizedChunk {
+	c := &tokenize.TokenizedChunk{}
+	for _, t := range append(append([]uint32{tokenize.CLS_TOKEN_ID}, tokens...), tokenize.SEP_TOKEN_ID) {
+		c.Tokens = append(c.Tokens, t)
+		c.InputMask = append(c.InputMask, 1)
+	}
+	// padding is ignored
+	c.Tokens = append(c.Tokens, 7)
+	c.InputMask = append(c.InputMask, 0)
+	return c
+}
+
+func dot(a, b []float32) float32 {
+	var s float32
+	for i := range a {
+		s += a[i] * b[i]
+	}
+	return s
+}
+
+func TestHashedEmbedder(t *testing.T) {
+	e, err := NewStaticEmbedder("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, b, c := newChunk(10, 11, 12), newChunk(10, 11, 13), newChunk(20, 21, 22)
+	again := newChunk(12, 11, 10)
+	if err := e.Embed(context.Background(), []*tokenize.TokenizedChunk{a, b, c, again}); err != nil {
+		t.Fatal(err)
+	}
+	if len(a.Embedding) != HASHED_DIM {
+		t.Fatalf("expected a %d-dim embedding, got %d", HASHED_DIM, len(a.Embedding))
+	}
+	if norm := dot(a.Embedding, a.Embedding); math.Abs(float64(norm)-1) > 1e-5 {
+		t.Errorf("expected a unit vector, got squared norm %f", norm)
+	}
+	if d := dot(a.Embedding, again.Embedding); math.Abs(float64(d)-1) > 1e-5 {
+		t.Errorf("expected the same tokens to embed the same, got similarity %f", d)
+	}
+	if dot(a.Embedding, b.Embedding) <= dot(a.Embedding, c.Embedding) {
+		t.Errorf("expected chunks sharing tokens to be more similar")
+	}
+}
+
+// writeSafetensors writes rows as a safetensors file with one tensor.
+func writeSafetensors(t *testing.T, rows [][]float32) string {
+	header := []byte(fmt.Sprintf(`{"__metadata__":{},"embeddings":{"dtype":"F32","shape":[%d,2],"data_offsets":[0,%d]}}`,
+		len(rows), len(rows)*8))
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, uint64(len(header)))
+	buf.Write(header)
+	for _, row := range rows {
+		binary.Write(&buf, binary.LittleEndian, row)
+	}
+	path := filepath.Join(t.TempDir(), "model.safetensors")
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestStaticModelFile(t *testing.T) {
+	// ids past the special tokens, whose rows are never read
+	base := tokenize.CLS_TOKEN_ID
+	if tokenize.SEP_TOKEN_ID > base {
+		base = tokenize.SEP_TOKEN_ID
+	}
+	base++
+	rows := make([][]float32, base)
+	for i := range rows {
+		rows[i] = []float32{0, 0}
+	}
+	rows = append(rows, []float32{3, 0}, []float32{0, 4})
+	e, err := NewStaticEmbedder(writeSafetensors(t, rows))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := newChunk(base, base+1)
+	if err := e.Embed(context.Background(), []*tokenize.TokenizedChunk{c}); err != nil {
+		t.Fatal(err)
+	}
+	if want := []float32{0.6, 0.8}; math.Abs(float64(c.Embedding[0]-want[0])) > 1e-6 || math.Abs(float64(c.Embedding[1]-want[1])) > 1e-6 {
+		t.Errorf("expected %v, got %v", want, c.Embedding)
+	}
+	if err := e.Embed(context.Background(), []*tokenize.TokenizedChunk{newChunk(base + 2)}); err == nil {
+		t.Errorf("expected an error for a token outside the vocabulary")
+	}
+	if err := e.Check(context.Background()); err == nil {
+		t.Errorf("expected Check to reject a model smaller than the vocabulary")
+	}
+
+	for len(rows) < tokenize.VocabSize() {
+		rows = append(rows, []float32{1, 1})
+	}
+	e, err = NewStaticEmbedder(writeSafetensors(t, rows))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Check(context.Background()); err != nil {
+		t.Errorf("expected Check to accept a model covering the vocabulary, got %v", err)
+	}
+}
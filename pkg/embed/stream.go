@@ -0,0 +1,230 @@
+package embed
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/skrider/softgrep/pb/triton-client"
+	"github.com/skrider/softgrep/pkg/tokenize"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// StreamEmbedder sends batches over long-lived ModelStreamInfer streams
+// instead of issuing one ModelInfer call per batch. Each of up to workers
+// concurrent calls has a stream of its own. A stream lives as long as the
+// context of the calls using it, and is reopened for calls with another
+// context. If the server does not implement streaming, StreamEmbedder falls
+// back to unary requests for the rest of its lifetime.
+type StreamEmbedder struct {
+	unary         *TritonEmbedder
+	streams       []*inferStream
+	idle          chan *inferStream
+	unimplemented int32
+}
+
+func NewStreamEmbedder(embedder *TritonEmbedder, workers int) *StreamEmbedder {
+	if workers < 1 {
+		workers = 1
+	}
+	e := &StreamEmbedder{
+		unary: embedder,
+		idle:  make(chan *inferStream, workers),
+	}
+	for i := 0; i < workers; i++ {
+		s := &inferStream{
+			client:  embedder.client,
+			pending: make(map[string]chan streamResult),
+		}
+		e.streams = append(e.streams, s)
+		e.idle <- s
+	}
+	return e
+}
+
+func (e *StreamEmbedder) Embed(ctx context.Context, chunks []*tokenize.TokenizedChunk) error {
+	if len(chunks) == 0 {
+		return nil
+	}
+	if atomic.LoadInt32(&e.unimplemented) == 1 {
+		return e.unary.Embed(ctx, chunks)
+	}
+
+	var s *inferStream
+	select {
+	case s = <-e.idle:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	req := e.unary.newRequest(chunks)
+	var res *triton_client.ModelInferResponse
+	err := e.unary.retrier.Do(ctx, func(attemptCtx context.Context) error {
+		var err error
+		res, err = s.infer(ctx, attemptCtx, req)
+		return err
+	})
+	e.idle <- s
+
+	if status.Code(err) == codes.Unimplemented {
+		if atomic.CompareAndSwapInt32(&e.unimplemented, 0, 1) {
+			log.Printf("Server does not support streaming inference, falling back to unary requests")
+		}
+		return e.unary.Embed(ctx, chunks)
+	}
+	if err != nil {
+		return err
+	}
+	return attachEmbeddings(res, chunks)
+}
+
+// Check checks the server streams are sent to. See TritonEmbedder.Check.
+func (e *StreamEmbedder) Check(ctx context.Context) error {
+	return e.unary.Check(ctx)
+}
+
+// Close closes every open stream, returning the first error. Requests
+// still waiting for a response fail.
+func (e *StreamEmbedder) Close() error {
+	var firstErr error
+	for _, s := range e.streams {
+		if err := s.close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+var errStreamClosed = errors.New("embed: stream closed")
+
+type streamResult struct {
+	res *triton_client.ModelInferResponse
+	err error
+}
+
+// inferStream multiplexes requests over a single stream, correlating
+// responses to callers by request id, so that a late response to an
+// abandoned request is not taken for the response to a later one. The
+// stream is opened lazily and reopened after it fails.
+type inferStream struct {
+	client  triton_client.GRPCInferenceServiceClient
+	mu      sync.Mutex
+	stream  triton_client.GRPCInferenceService_ModelStreamInferClient
+	done    <-chan struct{} // of the context stream was opened for
+	cancel  context.CancelFunc
+	pending map[string]chan streamResult
+	nextId  uint64
+}
+
+// infer sends req on the stream for streamCtx and waits for its response
+// until ctx is done. The stream is closed once streamCtx is done.
+func (s *inferStream) infer(streamCtx context.Context, ctx context.Context, req *triton_client.ModelInferRequest) (*triton_client.ModelInferResponse, error) {
+	ch := make(chan streamResult, 1)
+
+	s.mu.Lock()
+	if s.stream != nil && s.done != streamCtx.Done() {
+		s.closeLocked()
+	}
+	if s.stream == nil {
+		sctx, cancel := context.WithCancel(streamCtx)
+		stream, err := s.client.ModelStreamInfer(sctx)
+		if err != nil {
+			cancel()
+			s.mu.Unlock()
+			return nil, err
+		}
+		s.stream, s.done, s.cancel = stream, streamCtx.Done(), cancel
+		go s.recv(stream)
+	}
+	s.nextId++
+	id := strconv.FormatUint(s.nextId, 10)
+	req.Id = id
+	s.pending[id] = ch
+	// on io.EOF the stream has failed and recv will report the real status
+	if err := s.stream.Send(req); err != nil && err != io.EOF {
+		delete(s.pending, id)
+		s.mu.Unlock()
+		return nil, err
+	}
+	s.mu.Unlock()
+
+	select {
+	case r := <-ch:
+		return r.res, r.err
+	case <-ctx.Done():
+		s.mu.Lock()
+		delete(s.pending, id)
+		s.mu.Unlock()
+		return nil, ctx.Err()
+	}
+}
+
+func (s *inferStream) recv(stream triton_client.GRPCInferenceService_ModelStreamInferClient) {
+	for {
+		msg, err := stream.Recv()
+		if err != nil {
+			s.mu.Lock()
+			// the requests of a stream that was replaced have been
+			// abandoned, and pending belongs to its successor
+			if s.stream == stream {
+				s.stream = nil
+				s.cancel()
+				s.failPending(err)
+			}
+			s.mu.Unlock()
+			return
+		}
+
+		id := msg.GetInferResponse().GetId()
+		s.mu.Lock()
+		ch, ok := s.pending[id]
+		if !ok {
+			if msg.ErrorMessage != "" && s.stream == stream {
+				// the error cannot be attributed to a single request
+				s.failPending(fmt.Errorf("embed: stream error: %s", msg.ErrorMessage))
+			}
+			s.mu.Unlock()
+			continue
+		}
+		delete(s.pending, id)
+		s.mu.Unlock()
+
+		if msg.ErrorMessage != "" {
+			ch <- streamResult{err: fmt.Errorf("embed: request %s: %s", id, msg.ErrorMessage)}
+		} else {
+			ch <- streamResult{res: msg.InferResponse}
+		}
+	}
+}
+
+// failPending must be called with mu held.
+func (s *inferStream) failPending(err error) {
+	for id, ch := range s.pending {
+		ch <- streamResult{err: err}
+		delete(s.pending, id)
+	}
+}
+
+func (s *inferStream) close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closeLocked()
+}
+
+// closeLocked half-closes the stream, releases its context and fails the
+// requests still waiting for a response. It must be called with mu held.
+func (s *inferStream) closeLocked() error {
+	if s.stream == nil {
+		return nil
+	}
+	err := s.stream.CloseSend()
+	s.cancel()
+	s.stream = nil
+	s.failPending(errStreamClosed)
+	return err
+}
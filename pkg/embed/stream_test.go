@@ -0,0 +1,98 @@
+package embed_test
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/skrider/softgrep/pkg/chunk"
+	"github.com/skrider/softgrep/pkg/embed"
+	"github.com/skrider/softgrep/pkg/embed/embedtest"
+	"github.com/skrider/softgrep/pkg/tokenize"
+)
+
+// embedUnary returns the embedding of content from a unary request.
+func embedUnary(t *testing.T, e *embed.TritonEmbedder, content string) []float32 {
+	c := tokenize.NewTokenizer(&chunk.Chunk{Content: content}).Next()
+	if err := e.Embed(context.Background(), []*tokenize.TokenizedChunk{c}); err != nil {
+		t.Fatal(err)
+	}
+	return c.Embedding
+}
+
+// embedStream embeds content over streamer and checks that the embedding
+// matches want.
+func embedStream(t *testing.T, ctx context.Context, streamer *embed.StreamEmbedder, content string, want []float32) {
+	c := tokenize.NewTokenizer(&chunk.Chunk{Content: content}).Next()
+	if err := streamer.Embed(ctx, []*tokenize.TokenizedChunk{c}); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(c.Embedding, want) {
+		t.Errorf("%q: got the embedding of another request", content)
+	}
+}
+
+func TestStream(t *testing.T) {
+	s, err := embedtest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	e := embedtest.NewEmbedder(t, embedtest.NewConfig(t, s))
+	want := embedUnary(t, e, "func main() {}")
+	streamer := embed.NewStreamEmbedder(e, 2)
+	defer streamer.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	for i := 0; i < 3; i++ {
+		embedStream(t, ctx, streamer, "func main() {}", want)
+	}
+	if n := s.Infers(); n != 4 {
+		t.Errorf("expected 4 requests, got %d", n)
+	}
+	if n := s.Streams(); n != 2 {
+		t.Errorf("expected a stream per worker, got %d", n)
+	}
+
+	// the streams end with the context of the calls that opened them
+	cancel()
+	deadline := time.Now().Add(time.Second)
+	for s.Streams() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the streams to be closed once their context was cancelled")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	embedStream(t, context.Background(), streamer, "func main() {}", want)
+}
+
+// TestStreamOutOfOrder has the server answer a retried request before the
+// attempt that timed out, and checks that the late response is not taken
+// for the response to the next request.
+func TestStreamOutOfOrder(t *testing.T) {
+	s, err := embedtest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	cfg := embedtest.NewConfig(t, s)
+	cfg.Retries = 1
+	cfg.RetryBackoff = time.Millisecond
+	cfg.RequestTimeout = 50 * time.Millisecond
+	e := embedtest.NewEmbedder(t, cfg)
+	first := embedUnary(t, e, "func main() {}")
+	second := embedUnary(t, e, "def fibonacci(n)")
+	streamer := embed.NewStreamEmbedder(e, 1)
+	defer streamer.Close()
+
+	s.Hold(1)
+	ctx := context.Background()
+	embedStream(t, ctx, streamer, "func main() {}", first)
+	embedStream(t, ctx, streamer, "def fibonacci(n)", second)
+	if n := s.Infers(); n != 5 {
+		t.Errorf("expected 5 requests, got %d", n)
+	}
+}
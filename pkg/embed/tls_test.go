@@ -0,0 +1,216 @@
+package embed_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/skrider/softgrep/pkg/config"
+	"github.com/skrider/softgrep/pkg/embed"
+	"github.com/skrider/softgrep/pkg/embed/embedtest"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// certs is a CA and certificates it signed, written as PEM files.
+type certs struct {
+	dir    string
+	ca     *x509.Certificate
+	caKey  *ecdsa.PrivateKey
+	serial int64
+}
+
+func newCA(t *testing.T) *certs {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "softgrep test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &certs{dir: t.TempDir(), ca: ca, caKey: key, serial: 1}
+	c.write(t, "ca.pem", "CERTIFICATE", der)
+	return c
+}
+
+func (c *certs) write(t *testing.T, name string, typ string, der []byte) string {
+	path := filepath.Join(c.dir, name)
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// issue writes a certificate for name signed by the CA and returns the
+// paths of the certificate and its key.
+func (c *certs) issue(t *testing.T, name string, usage x509.ExtKeyUsage, hosts ...string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.serial++
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(c.serial),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, h)
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, c.ca, &key.PublicKey, c.caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c.write(t, name+".pem", "CERTIFICATE", der), c.write(t, name+"-key.pem", "EC PRIVATE KEY", keyDer)
+}
+
+func (c *certs) pool() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(c.ca)
+	return pool
+}
+
+// requireToken rejects requests that do not carry the bearer token.
+func requireToken(token string) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		md, _ := metadata.FromIncomingContext(ctx)
+		if got := md.Get("authorization"); len(got) != 1 || got[0] != "Bearer "+token {
+			return nil, status.Error(codes.Unauthenticated, "missing or wrong token")
+		}
+		return handler(ctx, req)
+	}
+}
+
+func TestTLS(t *testing.T) {
+	c := newCA(t)
+	other := newCA(t)
+	serverCert, serverKey := c.issue(t, "server", x509.ExtKeyUsageServerAuth, "triton.internal")
+	clientCert, clientKey := c.issue(t, "client", x509.ExtKeyUsageClientAuth)
+	pair, err := tls.LoadX509KeyPair(serverCert, serverKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := embedtest.NewServer(
+		grpc.Creds(credentials.NewTLS(&tls.Config{
+			Certificates: []tls.Certificate{pair},
+			ClientCAs:    c.pool(),
+			ClientAuth:   tls.RequireAndVerifyClientCert,
+		})),
+		grpc.UnaryInterceptor(requireToken("secret")),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	cases := []struct {
+		name   string
+		modify func(cfg *config.Config)
+		ok     bool
+	}{
+		{"mutual TLS with token", func(cfg *config.Config) {}, true},
+		{"plaintext", func(cfg *config.Config) {
+			cfg.TLS, cfg.TLSCA, cfg.TLSCert, cfg.TLSKey, cfg.TLSServerName = false, "", "", "", ""
+			cfg.Token = ""
+		}, false},
+		{"untrusted CA", func(cfg *config.Config) { cfg.TLSCA = filepath.Join(other.dir, "ca.pem") }, false},
+		{"wrong server name", func(cfg *config.Config) { cfg.TLSServerName = "" }, false},
+		{"no client certificate", func(cfg *config.Config) { cfg.TLSCert, cfg.TLSKey = "", "" }, false},
+		{"no token", func(cfg *config.Config) { cfg.Token = "" }, false},
+		{"wrong token", func(cfg *config.Config) { cfg.Token = "guess" }, false},
+	}
+	for _, tc := range cases {
+		cfg := embedtest.NewConfig(t, s)
+		cfg.TLS = true
+		cfg.TLSCA = filepath.Join(c.dir, "ca.pem")
+		cfg.TLSCert, cfg.TLSKey = clientCert, clientKey
+		// the certificate is for triton.internal, not the address dialed
+		cfg.TLSServerName = "triton.internal"
+		cfg.Token = "secret"
+		tc.modify(cfg)
+
+		err := embedtest.NewEmbedder(t, cfg).Check(context.Background())
+		if tc.ok && err != nil {
+			t.Errorf("%s: unexpected error %v", tc.name, err)
+		} else if !tc.ok && err == nil {
+			t.Errorf("%s: expected an error", tc.name)
+		}
+	}
+}
+
+func TestAPIKey(t *testing.T) {
+	c := newCA(t)
+	serverCert, serverKey := c.issue(t, "server", x509.ExtKeyUsageServerAuth, "127.0.0.1")
+	pair, err := tls.LoadX509KeyPair(serverCert, serverKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := embedtest.NewServer(
+		grpc.Creds(credentials.NewTLS(&tls.Config{Certificates: []tls.Certificate{pair}})),
+		grpc.UnaryInterceptor(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			md, _ := metadata.FromIncomingContext(ctx)
+			if got := md.Get("x-triton-key"); len(got) != 1 || got[0] != "k3y" {
+				return nil, status.Error(codes.Unauthenticated, "missing or wrong API key")
+			}
+			return handler(ctx, req)
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	cfg := embedtest.NewConfig(t, s)
+	cfg.TLSCA = filepath.Join(c.dir, "ca.pem")
+	cfg.APIKey, cfg.APIKeyHeader = "k3y", "X-Triton-Key"
+	if err := embedtest.NewEmbedder(t, cfg).Check(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// credentials are never sent in the clear
+	cfg = embedtest.NewConfig(t, s)
+	cfg.APIKey = "k3y"
+	if _, err := embed.DialOptions(cfg); err == nil {
+		t.Error("expected an error for an API key without TLS")
+	}
+}
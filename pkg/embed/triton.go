@@ -0,0 +1,220 @@
+package embed
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strconv"
+	"sync"
+
+	"github.com/skrider/softgrep/pb/triton-client"
+	"github.com/skrider/softgrep/pkg/tokenize"
+)
+
+// tensor names as exported by scripts/download_model.py
+const INPUT_IDS = "input_ids"
+const ATTENTION_MASK = "attention_mask"
+const TOKEN_TYPE_IDS = "token_type_ids"
+const EMBEDDINGS = "embeddings"
+
+const INT64 = "INT64"
+const FP32 = "FP32"
+
+type TritonEmbedder struct {
+	client  triton_client.GRPCInferenceServiceClient
+	model   string
+	retrier *Retrier
+
+	mu      sync.Mutex
+	version string // empty for the latest version, until resolved
+}
+
+func NewTritonEmbedder(client triton_client.GRPCInferenceServiceClient, model string, version string) *TritonEmbedder {
+	return &TritonEmbedder{
+		client:  client,
+		model:   model,
+		version: version,
+	}
+}
+
+// SetRetrier makes the embedder retry failed requests with r. Without a
+// Retrier every request is tried once.
+func (e *TritonEmbedder) SetRetrier(r *Retrier) {
+	e.retrier = r
+}
+
+func (e *TritonEmbedder) getVersion() string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.version
+}
+
+// ResolveVersion returns the model version the embedder uses. If none was
+// given, it asks the server for the latest version of the model and pins
+// every later request to it, so that embeddings of one run all come from
+// the same version and can be cached under it.
+func (e *TritonEmbedder) ResolveVersion(ctx context.Context) (string, error) {
+	if v := e.getVersion(); v != "" {
+		return v, nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, CHECK_TIMEOUT)
+	defer cancel()
+	metadata, err := e.client.ModelMetadata(ctx, &triton_client.ModelMetadataRequest{Name: e.model})
+	if err != nil {
+		return "", fmt.Errorf("embed: fetching metadata of model %s: %w", e.model, err)
+	}
+	latest := latestVersion(metadata.Versions)
+	if latest == "" {
+		return "", fmt.Errorf("embed: model %s reports no versions", e.model)
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.version == "" {
+		e.version = latest
+	}
+	return e.version, nil
+}
+
+// latestVersion returns the highest of versions. Triton versions are
+// numbers, anything else sorts before them.
+func latestVersion(versions []string) string {
+	latest, latestN := "", int64(-1)
+	for _, v := range versions {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			n = -1
+		}
+		if latest == "" || n > latestN {
+			latest, latestN = v, n
+		}
+	}
+	return latest
+}
+
+// Embed sends chunks to the inference server as a single [N, MAX_LEN] batch
+// and attaches the resulting embedding to each chunk.
+func (e *TritonEmbedder) Embed(ctx context.Context, chunks []*tokenize.TokenizedChunk) error {
+	if len(chunks) == 0 {
+		return nil
+	}
+	req := e.newRequest(chunks)
+	var res *triton_client.ModelInferResponse
+	err := e.retrier.Do(ctx, func(ctx context.Context) error {
+		var err error
+		res, err = e.client.ModelInfer(ctx, req)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return attachEmbeddings(res, chunks)
+}
+
+func attachEmbeddings(res *triton_client.ModelInferResponse, chunks []*tokenize.TokenizedChunk) error {
+	embeddings, err := parseOutput(res, EMBEDDINGS, len(chunks))
+	if err != nil {
+		return err
+	}
+	for i, c := range chunks {
+		c.Embedding = embeddings[i]
+	}
+	return nil
+}
+
+func (e *TritonEmbedder) newRequest(chunks []*tokenize.TokenizedChunk) *triton_client.ModelInferRequest {
+	return newInferRequest(e.model, e.getVersion(), EMBEDDINGS, chunks)
+}
+
+// newInferRequest asks model for output over chunks as one [N, MAX_LEN]
+// batch.
+func newInferRequest(model string, version string, output string, chunks []*tokenize.TokenizedChunk) *triton_client.ModelInferRequest {
+	n := len(chunks)
+	ids := make([][]uint32, n)
+	mask := make([][]uint32, n)
+	types := make([][]uint32, n)
+	for i, c := range chunks {
+		// TokenizedChunk.InputIds holds the segment ids, not the token ids
+		ids[i] = c.Tokens
+		mask[i] = c.InputMask
+		types[i] = c.InputIds
+	}
+
+	shape := []int64{int64(n), tokenize.MAX_LEN}
+	return &triton_client.ModelInferRequest{
+		ModelName:    model,
+		ModelVersion: version,
+		Inputs: []*triton_client.ModelInferRequest_InferInputTensor{
+			{Name: INPUT_IDS, Datatype: INT64, Shape: shape},
+			{Name: ATTENTION_MASK, Datatype: INT64, Shape: shape},
+			{Name: TOKEN_TYPE_IDS, Datatype: INT64, Shape: shape},
+		},
+		Outputs: []*triton_client.ModelInferRequest_InferRequestedOutputTensor{
+			{Name: output},
+		},
+		RawInputContents: [][]byte{
+			encodeInt64(ids),
+			encodeInt64(mask),
+			encodeInt64(types),
+		},
+	}
+}
+
+// encodeInt64 flattens rows into the little-endian row-major layout triton
+// expects for raw INT64 tensors.
+func encodeInt64(rows [][]uint32) []byte {
+	size := 0
+	for _, r := range rows {
+		size += len(r)
+	}
+	buf := make([]byte, 8*size)
+	off := 0
+	for _, r := range rows {
+		for _, v := range r {
+			binary.LittleEndian.PutUint64(buf[off:], uint64(v))
+			off += 8
+		}
+	}
+	return buf
+}
+
+// parseOutput returns the rows of the [n, dim] FP32 output called name.
+func parseOutput(res *triton_client.ModelInferResponse, name string, n int) ([][]float32, error) {
+	for i, out := range res.Outputs {
+		if out.Name != name {
+			continue
+		}
+		if out.Datatype != FP32 {
+			return nil, fmt.Errorf("embed: unexpected datatype %s for output %s", out.Datatype, out.Name)
+		}
+		if len(out.Shape) != 2 || out.Shape[0] != int64(n) {
+			return nil, fmt.Errorf("embed: unexpected shape %v for output %s", out.Shape, out.Name)
+		}
+		dim := int(out.Shape[1])
+
+		var flat []float32
+		if i < len(res.RawOutputContents) {
+			flat = decodeFloat32(res.RawOutputContents[i])
+		} else if out.Contents != nil {
+			flat = out.Contents.Fp32Contents
+		}
+		if len(flat) != n*dim {
+			return nil, fmt.Errorf("embed: expected %d values for output %s, got %d", n*dim, out.Name, len(flat))
+		}
+
+		embeddings := make([][]float32, n)
+		for j := range embeddings {
+			embeddings[j] = flat[j*dim : (j+1)*dim]
+		}
+		return embeddings, nil
+	}
+	return nil, fmt.Errorf("embed: output %s missing from response", name)
+}
+
+func decodeFloat32(b []byte) []float32 {
+	out := make([]float32, len(b)/4)
+	for i := range out {
+		out[i] = math.Float32frombits(binary.LittleEndian.Uint32(b[4*i:]))
+	}
+	return out
+}
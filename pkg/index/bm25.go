@@ -0,0 +1,153 @@
+package index
+
+import (
+	"math"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// BM25 parameters, the usual defaults
+const BM25_K1 = 1.2
+const BM25_B = 0.75
+
+type posting struct {
+	ID int32
+	TF int32
+}
+
+// BM25 is an inverted index that ranks chunks by the Okapi BM25 score of
+// the terms they share with a query. It catches exact identifiers that
+// embeddings blur together. Terms are the identifiers and numbers in a
+// chunk, see Terms. BM25 only stores the ids of chunks, and looks up the
+// chunks of results in the records it was created with, usually the Index
+// the same chunks were added to. BM25 is safe for concurrent use.
+type BM25 struct {
+	records Records
+
+	mu       sync.RWMutex
+	postings map[string][]posting
+	lengths  []int32 // number of terms in each chunk, by id
+	docs     int
+	total    int64
+}
+
+func NewBM25(records Records) *BM25 {
+	return &BM25{records: records, postings: make(map[string][]posting)}
+}
+
+// Terms splits text into lowercase identifiers and numbers. Identifiers
+// made of several words, like parseConfig or parse_config, also yield each
+// word, so a query matches both the identifier and its parts.
+func Terms(text string) []string {
+	var terms []string
+	words := strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_'
+	})
+	for _, word := range words {
+		parts := splitIdentifier(word)
+		if len(parts) == 0 {
+			continue
+		}
+		terms = append(terms, strings.ToLower(strings.Trim(word, "_")))
+		if len(parts) > 1 {
+			for _, p := range parts {
+				terms = append(terms, strings.ToLower(p))
+			}
+		}
+	}
+	return terms
+}
+
+// splitIdentifier splits word at underscores and lower to upper case
+// transitions, keeping acronyms together: HTTPServer_port gives HTTP,
+// Server and port.
+func splitIdentifier(word string) []string {
+	var parts []string
+	for _, field := range strings.Split(word, "_") {
+		runes := []rune(field)
+		start := 0
+		for i := 1; i < len(runes); i++ {
+			lowerToUpper := unicode.IsLower(runes[i-1]) && unicode.IsUpper(runes[i])
+			acronymEnd := unicode.IsUpper(runes[i-1]) && unicode.IsUpper(runes[i]) && i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if lowerToUpper || acronymEnd {
+				parts = append(parts, string(runes[start:i]))
+				start = i
+			}
+		}
+		if start < len(runes) {
+			parts = append(parts, string(runes[start:]))
+		}
+	}
+	return parts
+}
+
+// Add indexes the terms of text, the content of the chunk with the given
+// id in b's records.
+func (b *BM25) Add(id int, text string) {
+	terms := Terms(text)
+	tf := make(map[string]int32)
+	for _, t := range terms {
+		tf[t]++
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for len(b.lengths) <= id {
+		b.lengths = append(b.lengths, 0)
+	}
+	b.lengths[id] = int32(len(terms))
+	b.docs++
+	b.total += int64(len(terms))
+	for t, n := range tf {
+		b.postings[t] = append(b.postings[t], posting{ID: int32(id), TF: n})
+	}
+}
+
+// Search returns up to k chunks containing a term of query, best first.
+func (b *BM25) Search(query string, k int) []Result {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	n := b.docs
+	if n == 0 {
+		return nil
+	}
+	avgLen := float64(b.total) / float64(n)
+
+	scores := make(map[int32]float64)
+	seen := make(map[string]bool)
+	for _, t := range Terms(query) {
+		if seen[t] {
+			continue
+		}
+		seen[t] = true
+		postings := b.postings[t]
+		if len(postings) == 0 {
+			continue
+		}
+		df := float64(len(postings))
+		idf := math.Log(1 + (float64(n)-df+0.5)/(df+0.5))
+		for _, p := range postings {
+			tf := float64(p.TF)
+			norm := BM25_K1 * (1 - BM25_B + BM25_B*float64(b.lengths[p.ID])/avgLen)
+			scores[p.ID] += idf * tf * (BM25_K1 + 1) / (tf + norm)
+		}
+	}
+
+	top := newTopK(k)
+	for id, score := range scores {
+		top.push(int(id), float32(score))
+	}
+	candidates := top.sorted()
+	results := make([]Result, len(candidates))
+	for i, c := range candidates {
+		results[i] = Result{Chunk: b.records.Record(c.id), Score: c.score}
+	}
+	return results
+}
+
+func (b *BM25) Len() int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.docs
+}
@@ -0,0 +1,126 @@
+package index
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/skrider/softgrep/pkg/chunk"
+)
+
+func TestTerms(t *testing.T) {
+	got := Terms("func parseHTTPRequest(req_body []byte) int64 { return 42 }")
+	want := []string{"func", "parsehttprequest", "parse", "http", "request", "req_body", "req", "body", "byte", "int64", "return", "42"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+}
+
+func newBM25(contents ...string) (*BM25, *ChunkList) {
+	records := NewChunkList()
+	b := NewBM25(records)
+	for _, c := range contents {
+		b.Add(records.Add(chunk.Chunk{Path: c, Content: c}), c)
+	}
+	return b, records
+}
+
+func TestBM25(t *testing.T) {
+	b, records := newBM25(
+		"func parseConfig(path string) (*Config, error)",
+		"func parse(text string) []string",
+		"// configuration is read once at startup",
+		"func writeConfig(w io.Writer, c *Config) error",
+	)
+	results := b.Search("parseConfig", 10)
+	if len(results) != 3 {
+		t.Fatalf("expected 3 chunks sharing a term, got %d", len(results))
+	}
+	if results[0].Content != "func parseConfig(path string) (*Config, error)" {
+		t.Errorf("expected the exact identifier first, got %q", results[0].Content)
+	}
+	if r := b.Search("unrelated", 10); len(r) != 0 {
+		t.Errorf("expected no results, got %v", r)
+	}
+
+	var buf bytes.Buffer
+	if err := SaveBM25(&buf, b); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadBM25(&buf, records)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := loaded.Search("parseConfig", 10); !reflect.DeepEqual(got, results) {
+		t.Errorf("expected %v after loading, got %v", results, got)
+	}
+}
+
+func TestBM25Records(t *testing.T) {
+	// results come from the index the chunks were added to
+	flat := NewFlat()
+	b := NewBM25(flat)
+	for i, content := range []string{"func openFile()", "func closeFile()"} {
+		if err := flat.Add([]float32{1, float32(i)}, chunk.Chunk{Path: "file.go", Content: content, StartByte: uint32(20 * i)}); err != nil {
+			t.Fatal(err)
+		}
+		b.Add(flat.Len()-1, content)
+	}
+	results := b.Search("closeFile", 1)
+	if len(results) != 1 || results[0].Chunk != flat.Record(1) {
+		t.Fatalf("expected the second chunk of the index, got %v", results)
+	}
+
+	list := NewChunkList()
+	for i := 0; i < flat.Len(); i++ {
+		list.Add(flat.Record(i))
+	}
+	var buf bytes.Buffer
+	if err := SaveChunkList(&buf, list); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadChunkList(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Len() != 2 || loaded.Record(1) != flat.Record(1) {
+		t.Errorf("expected both chunks back after loading, got %d", loaded.Len())
+	}
+}
+
+func TestFuse(t *testing.T) {
+	result := func(path string, score float32) Result {
+		return Result{Chunk: chunk.Chunk{Path: path}, Score: score}
+	}
+	semantic := []Result{result("a", 0.9), result("b", 0.8), result("c", 0.1)}
+	lexical := []Result{result("b", 12), result("d", 11), result("c", 2)}
+
+	cases := []struct {
+		method string
+		weight float64
+		want   []string
+	}{
+		// b ranks well in both lists, and rank fusion favors c for being in both
+		{RRF_FUSION, 0.5, []string{"b", "c", "a"}},
+		{WEIGHTED_FUSION, 0.5, []string{"b", "a", "d"}},
+		// ties keep semantic order
+		{WEIGHTED_FUSION, 0, []string{"a", "b", "c"}},
+		{WEIGHTED_FUSION, 1, []string{"b", "d", "a"}},
+	}
+	for _, c := range cases {
+		results, err := Fuse(c.method, c.weight, semantic, lexical, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, r := range results {
+			got = append(got, r.Path)
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s %g: expected %v, got %v", c.method, c.weight, c.want, got)
+		}
+	}
+	if _, err := Fuse("max", 0.5, semantic, lexical, 3); err == nil {
+		t.Errorf("expected an error for an unknown method")
+	}
+}
@@ -0,0 +1,38 @@
+package index
+
+import (
+	"sync"
+
+	"github.com/skrider/softgrep/pkg/chunk"
+)
+
+// ChunkList holds the records of chunks indexed without embeddings, for
+// lexical search alone. ChunkList is safe for concurrent use.
+type ChunkList struct {
+	mu     sync.RWMutex
+	chunks []chunk.Chunk
+}
+
+func NewChunkList() *ChunkList {
+	return &ChunkList{}
+}
+
+// Add appends c and returns its id.
+func (l *ChunkList) Add(c chunk.Chunk) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.chunks = append(l.chunks, c)
+	return len(l.chunks) - 1
+}
+
+func (l *ChunkList) Record(id int) chunk.Chunk {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.chunks[id]
+}
+
+func (l *ChunkList) Len() int {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return len(l.chunks)
+}
@@ -0,0 +1,66 @@
+package index
+
+import (
+	"sync"
+
+	"github.com/skrider/softgrep/pkg/chunk"
+)
+
+// Flat is an exhaustive index. Every query is compared against every stored
+// vector, which is exact and fast enough for small to medium repositories.
+type Flat struct {
+	mu      sync.RWMutex
+	dim     int
+	vectors []float32
+	chunks  []chunk.Chunk
+}
+
+func NewFlat() *Flat {
+	return &Flat{}
+}
+
+func (f *Flat) Add(v []float32, c chunk.Chunk) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.dim == 0 {
+		f.dim = len(v)
+	}
+	if len(v) != f.dim {
+		return DimensionMismatchError
+	}
+	f.vectors = append(f.vectors, normalize(v)...)
+	f.chunks = append(f.chunks, c)
+	return nil
+}
+
+func (f *Flat) Search(q []float32, k int) []Result {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	if len(q) != f.dim {
+		return nil
+	}
+	q = normalize(q)
+	top := newTopK(k)
+	for i := range f.chunks {
+		top.push(i, dot(q, f.vectors[i*f.dim:(i+1)*f.dim]))
+	}
+
+	candidates := top.sorted()
+	results := make([]Result, len(candidates))
+	for i, c := range candidates {
+		results[i] = Result{Chunk: f.chunks[c.id], Score: c.score}
+	}
+	return results
+}
+
+func (f *Flat) Record(id int) chunk.Chunk {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.chunks[id]
+}
+
+func (f *Flat) Len() int {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return len(f.chunks)
+}
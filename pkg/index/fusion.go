@@ -0,0 +1,80 @@
+package index
+
+import (
+	"fmt"
+	"sort"
+)
+
+const RRF_FUSION = "rrf"
+const WEIGHTED_FUSION = "weighted"
+
+// RRF_K damps the lead of the top ranks in reciprocal rank fusion, the
+// value from Cormack et al.
+const RRF_K = 60
+
+type resultKey struct {
+	path       string
+	start, end uint32
+}
+
+func keyOf(r Result) resultKey {
+	return resultKey{r.Path, r.StartByte, r.EndByte}
+}
+
+// Fuse merges semantic and lexical results for the same query into up to
+// k results, best first. With RRF_FUSION a result scores the sum of
+// 1/(RRF_K+rank) over the lists it appears in. With WEIGHTED_FUSION it
+// scores the sum of its scores, each scaled to [0, 1] within its list.
+// Lexical scores are weighted by lexicalWeight and semantic scores by
+// 1-lexicalWeight.
+func Fuse(method string, lexicalWeight float64, semantic []Result, lexical []Result, k int) ([]Result, error) {
+	if lexicalWeight < 0 || lexicalWeight > 1 {
+		return nil, fmt.Errorf("index: lexical weight %g is not between 0 and 1", lexicalWeight)
+	}
+	var score func(list []Result, rank int) float64
+	switch method {
+	case RRF_FUSION:
+		score = func(list []Result, rank int) float64 {
+			return 1 / float64(RRF_K+rank+1)
+		}
+	case WEIGHTED_FUSION:
+		score = func(list []Result, rank int) float64 {
+			// lists are sorted, so the first and last scores bound the list
+			hi, lo := list[0].Score, list[len(list)-1].Score
+			if hi == lo {
+				return 1
+			}
+			return float64(list[rank].Score-lo) / float64(hi-lo)
+		}
+	default:
+		return nil, fmt.Errorf("index: unknown fusion method %q", method)
+	}
+
+	fused := make(map[resultKey]*Result)
+	var order []resultKey
+	add := func(list []Result, weight float64) {
+		for rank, r := range list {
+			key := keyOf(r)
+			f, ok := fused[key]
+			if !ok {
+				f = &Result{Chunk: r.Chunk}
+				fused[key] = f
+				order = append(order, key)
+			}
+			f.Score += float32(weight * score(list, rank))
+		}
+	}
+	add(semantic, 1-lexicalWeight)
+	add(lexical, lexicalWeight)
+
+	results := make([]Result, len(order))
+	for i, key := range order {
+		results[i] = *fused[key]
+	}
+	// stable, so ties keep semantic order
+	sort.SliceStable(results, func(i, j int) bool { return results[i].Score > results[j].Score })
+	if len(results) > k {
+		results = results[:k]
+	}
+	return results, nil
+}
@@ -0,0 +1,76 @@
+package index
+
+import (
+	"container/heap"
+	"sort"
+)
+
+type candidate struct {
+	id    int
+	score float32
+}
+
+// minHeap keeps the worst candidate at the root so it can be evicted
+// when a better one arrives.
+type minHeap []candidate
+
+func (h minHeap) Len() int            { return len(h) }
+func (h minHeap) Less(i, j int) bool  { return h[i].score < h[j].score }
+func (h minHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *minHeap) Push(x interface{}) { *h = append(*h, x.(candidate)) }
+func (h *minHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	x := old[n-1]
+	*h = old[:n-1]
+	return x
+}
+
+// topK accumulates the k highest scoring candidates.
+type topK struct {
+	k int
+	h minHeap
+}
+
+func newTopK(k int) *topK {
+	return &topK{k: k, h: make(minHeap, 0, k)}
+}
+
+func (t *topK) push(id int, score float32) {
+	if t.k <= 0 {
+		return
+	}
+	if len(t.h) < t.k {
+		heap.Push(&t.h, candidate{id: id, score: score})
+	} else if score > t.h[0].score {
+		t.h[0] = candidate{id: id, score: score}
+		heap.Fix(&t.h, 0)
+	}
+}
+
+// sorted returns the candidates from best to worst.
+func (t *topK) sorted() []candidate {
+	out := make([]candidate, len(t.h))
+	copy(out, t.h)
+	sort.Slice(out, func(i, j int) bool { return out[i].score > out[j].score })
+	return out
+}
+
+// maxHeap keeps the best candidate at the root.
+type maxHeap []candidate
+
+func (h maxHeap) Len() int            { return len(h) }
+func (h maxHeap) Less(i, j int) bool  { return h[i].score > h[j].score }
+func (h maxHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *maxHeap) Push(x interface{}) { *h = append(*h, x.(candidate)) }
+func (h *maxHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	x := old[n-1]
+	*h = old[:n-1]
+	return x
+}
+
+func (c candidate) id32() int32 {
+	return int32(c.id)
+}
@@ -0,0 +1,247 @@
+package index
+
+import (
+	"container/heap"
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+
+	"github.com/skrider/softgrep/pkg/chunk"
+)
+
+// HNSW is an approximate index based on hierarchical navigable small world
+// graphs (Malkov & Yashunin, https://arxiv.org/abs/1603.09320). Queries
+// visit a small fraction of the stored vectors, trading a little recall for
+// search time that grows logarithmically with the size of the index.
+type HNSW struct {
+	mu sync.RWMutex
+
+	// maximum number of neighbors per node above layer 0. Layer 0 allows 2*m.
+	m              int
+	efConstruction int
+	efSearch       int
+	ml             float64
+
+	dim     int
+	vectors [][]float32
+	chunks  []chunk.Chunk
+	// friends[node][layer] lists the neighbors of node on layer
+	friends  [][][]int32
+	entry    int
+	maxLevel int
+
+	rng *rand.Rand
+}
+
+func NewHNSW(m int, efConstruction int, efSearch int) *HNSW {
+	if m < 2 {
+		m = 2
+	}
+	return &HNSW{
+		m:              m,
+		efConstruction: efConstruction,
+		efSearch:       efSearch,
+		ml:             1 / math.Log(float64(m)),
+		entry:          -1,
+		rng:            rand.New(rand.NewSource(1)),
+	}
+}
+
+func (h *HNSW) maxFriends(layer int) int {
+	if layer == 0 {
+		return 2 * h.m
+	}
+	return h.m
+}
+
+func (h *HNSW) sim(q []float32, id int32) float32 {
+	return dot(q, h.vectors[id])
+}
+
+func (h *HNSW) Add(v []float32, c chunk.Chunk) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.dim == 0 {
+		h.dim = len(v)
+	}
+	if len(v) != h.dim {
+		return DimensionMismatchError
+	}
+
+	q := normalize(v)
+	id := int32(len(h.vectors))
+	level := int(math.Floor(-math.Log(1-h.rng.Float64()) * h.ml))
+	h.vectors = append(h.vectors, q)
+	h.chunks = append(h.chunks, c)
+	h.friends = append(h.friends, make([][]int32, level+1))
+
+	if h.entry < 0 {
+		h.entry = int(id)
+		h.maxLevel = level
+		return nil
+	}
+
+	ep := int32(h.entry)
+	for l := h.maxLevel; l > level; l-- {
+		ep = h.greedy(q, ep, l)
+	}
+	top := level
+	if h.maxLevel < top {
+		top = h.maxLevel
+	}
+	for l := top; l >= 0; l-- {
+		candidates := h.searchLayer(q, ep, h.efConstruction, l)
+		neighbors := h.selectNeighbors(candidates, h.m)
+		h.friends[id][l] = neighbors
+		for _, n := range neighbors {
+			h.link(n, id, l)
+		}
+		ep = candidates[0].id32()
+	}
+
+	if level > h.maxLevel {
+		h.entry = int(id)
+		h.maxLevel = level
+	}
+	return nil
+}
+
+// link adds an edge from n to id on layer, pruning n's neighbors if it now
+// has too many.
+func (h *HNSW) link(n int32, id int32, layer int) {
+	friends := append(h.friends[n][layer], id)
+	if len(friends) > h.maxFriends(layer) {
+		candidates := make([]candidate, len(friends))
+		for i, f := range friends {
+			candidates[i] = candidate{id: int(f), score: dot(h.vectors[n], h.vectors[f])}
+		}
+		sort.Slice(candidates, func(i, j int) bool { return candidates[i].score > candidates[j].score })
+		friends = h.selectNeighbors(candidates, h.maxFriends(layer))
+	}
+	h.friends[n][layer] = friends
+}
+
+// selectNeighbors implements the neighbor selection heuristic from the
+// paper: a candidate is kept only if it is closer to the base element than
+// to any neighbor already kept, which spreads edges across clusters. Pruned
+// candidates fill any remaining slots. candidates must be sorted best first.
+func (h *HNSW) selectNeighbors(candidates []candidate, m int) []int32 {
+	selected := make([]int32, 0, m)
+	var pruned []int32
+	for _, c := range candidates {
+		if len(selected) >= m {
+			break
+		}
+		keep := true
+		for _, s := range selected {
+			if h.sim(h.vectors[c.id], s) > c.score {
+				keep = false
+				break
+			}
+		}
+		if keep {
+			selected = append(selected, c.id32())
+		} else {
+			pruned = append(pruned, c.id32())
+		}
+	}
+	for _, p := range pruned {
+		if len(selected) >= m {
+			break
+		}
+		selected = append(selected, p)
+	}
+	return selected
+}
+
+// greedy walks layer from ep towards q, stopping at a local optimum.
+func (h *HNSW) greedy(q []float32, ep int32, layer int) int32 {
+	best := h.sim(q, ep)
+	for changed := true; changed; {
+		changed = false
+		for _, f := range h.friends[ep][layer] {
+			if s := h.sim(q, f); s > best {
+				best, ep, changed = s, f, true
+			}
+		}
+	}
+	return ep
+}
+
+// searchLayer returns the ef nearest neighbors of q on layer reachable from
+// ep, sorted best first.
+func (h *HNSW) searchLayer(q []float32, ep int32, ef int, layer int) []candidate {
+	if ef < 1 {
+		ef = 1
+	}
+	visited := map[int32]bool{ep: true}
+	start := candidate{id: int(ep), score: h.sim(q, ep)}
+	candidates := &maxHeap{start}
+	results := &minHeap{start}
+
+	for candidates.Len() > 0 {
+		c := heap.Pop(candidates).(candidate)
+		if c.score < (*results)[0].score && results.Len() >= ef {
+			break
+		}
+		for _, f := range h.friends[c.id][layer] {
+			if visited[f] {
+				continue
+			}
+			visited[f] = true
+			s := h.sim(q, f)
+			if results.Len() < ef || s > (*results)[0].score {
+				heap.Push(candidates, candidate{id: int(f), score: s})
+				heap.Push(results, candidate{id: int(f), score: s})
+				if results.Len() > ef {
+					heap.Pop(results)
+				}
+			}
+		}
+	}
+
+	out := []candidate(*results)
+	sort.Slice(out, func(i, j int) bool { return out[i].score > out[j].score })
+	return out
+}
+
+func (h *HNSW) Search(q []float32, k int) []Result {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if h.entry < 0 || len(q) != h.dim || k <= 0 {
+		return nil
+	}
+
+	q = normalize(q)
+	ep := int32(h.entry)
+	for l := h.maxLevel; l > 0; l-- {
+		ep = h.greedy(q, ep, l)
+	}
+	ef := h.efSearch
+	if ef < k {
+		ef = k
+	}
+	candidates := h.searchLayer(q, ep, ef, 0)
+	if len(candidates) > k {
+		candidates = candidates[:k]
+	}
+
+	results := make([]Result, len(candidates))
+	for i, c := range candidates {
+		results[i] = Result{Chunk: h.chunks[c.id], Score: c.score}
+	}
+	return results
+}
+
+func (h *HNSW) Record(id int) chunk.Chunk {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.chunks[id]
+}
+
+func (h *HNSW) Len() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.chunks)
+}
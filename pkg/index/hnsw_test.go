@@ -0,0 +1,107 @@
+package index
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/skrider/softgrep/pkg/chunk"
+)
+
+func randomVectors(rng *rand.Rand, n int, dim int) [][]float32 {
+	vectors := make([][]float32, n)
+	for i := range vectors {
+		v := make([]float32, dim)
+		for j := range v {
+			v[j] = float32(rng.NormFloat64())
+		}
+		vectors[i] = v
+	}
+	return vectors
+}
+
+func buildIndexes(t *testing.T, vectors [][]float32) (*Flat, *HNSW) {
+	flat := NewFlat()
+	hnsw := NewHNSW(16, 200, 64)
+	for i, v := range vectors {
+		c := chunk.Chunk{Content: fmt.Sprint(i)}
+		if err := flat.Add(v, c); err != nil {
+			t.Fatal(err)
+		}
+		if err := hnsw.Add(v, c); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return flat, hnsw
+}
+
+func recall(exact []Result, approx []Result) float64 {
+	want := make(map[string]bool, len(exact))
+	for _, r := range exact {
+		want[r.Content] = true
+	}
+	hits := 0
+	for _, r := range approx {
+		if want[r.Content] {
+			hits++
+		}
+	}
+	return float64(hits) / float64(len(exact))
+}
+
+func TestHNSWRecall(t *testing.T) {
+	const n, dim, k, queries = 2000, 32, 10, 50
+	rng := rand.New(rand.NewSource(42))
+	flat, hnsw := buildIndexes(t, randomVectors(rng, n, dim))
+
+	if hnsw.Len() != n {
+		t.Fatalf("expected %d vectors, got %d", n, hnsw.Len())
+	}
+
+	total := 0.0
+	for _, q := range randomVectors(rng, queries, dim) {
+		exact := flat.Search(q, k)
+		approx := hnsw.Search(q, k)
+		if len(approx) != k {
+			t.Fatalf("expected %d results, got %d", k, len(approx))
+		}
+		for i := 1; i < len(approx); i++ {
+			if approx[i].Score > approx[i-1].Score {
+				t.Fatalf("results not sorted: %v", approx)
+			}
+		}
+		total += recall(exact, approx)
+	}
+	if r := total / queries; r < 0.9 {
+		t.Errorf("recall@%d = %.3f, want >= 0.9", k, r)
+	}
+}
+
+func TestSaveLoad(t *testing.T) {
+	rng := rand.New(rand.NewSource(7))
+	vectors := randomVectors(rng, 500, 16)
+	flat, hnsw := buildIndexes(t, vectors)
+	q := randomVectors(rng, 1, 16)[0]
+
+	for _, idx := range []Index{flat, hnsw} {
+		var buf bytes.Buffer
+		if err := Save(&buf, idx); err != nil {
+			t.Fatal(err)
+		}
+		loaded, err := Load(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if loaded.Len() != idx.Len() {
+			t.Fatalf("%T: expected %d vectors after load, got %d", idx, idx.Len(), loaded.Len())
+		}
+		want := idx.Search(q, 5)
+		got := loaded.Search(q, 5)
+		for i := range want {
+			if want[i] != got[i] {
+				t.Fatalf("%T: result %d differs after load: %v != %v", idx, i, want[i], got[i])
+			}
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package index
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/skrider/softgrep/pkg/chunk"
+	"github.com/skrider/softgrep/pkg/config"
+)
+
+const FLAT_INDEX = "flat"
+const HNSW_INDEX = "hnsw"
+const QUANTIZED_INDEX = "quantized"
+
+const NO_QUANTIZATION = "none"
+const SCALAR_QUANTIZATION = "sq8"
+const PRODUCT_QUANTIZATION = "pq"
+
+var DimensionMismatchError = errors.New("index: vector dimension mismatch")
+
+// Result is an indexed chunk and its similarity to the query.
+type Result struct {
+	chunk.Chunk
+	Score float32
+}
+
+// Records holds indexed chunks, numbered from 0 in the order they were
+// added.
+type Records interface {
+	// Record returns the chunk with the given id, which must be less than
+	// Len.
+	Record(id int) chunk.Chunk
+	Len() int
+}
+
+// Index stores embeddings and answers nearest neighbor queries by cosine
+// similarity. The chunk added with each embedding is its record.
+// Implementations must be safe for concurrent use.
+type Index interface {
+	Records
+	Add(v []float32, c chunk.Chunk) error
+	// Search returns up to k results ordered from most to least similar.
+	Search(q []float32, k int) []Result
+}
+
+// New returns an empty index of the type selected by config.
+func New(config *config.Config) (Index, error) {
+	if config.Quantize != NO_QUANTIZATION && config.IndexType != FLAT_INDEX {
+		return nil, fmt.Errorf("index: quantization is only supported by the %s index", FLAT_INDEX)
+	}
+	switch config.Quantize {
+	case NO_QUANTIZATION:
+	case SCALAR_QUANTIZATION:
+		return NewQuantized(NewScalarQuantizer(), config.Rerank), nil
+	case PRODUCT_QUANTIZATION:
+		return NewQuantized(NewProductQuantizer(config.PQSubspaces), config.Rerank), nil
+	default:
+		return nil, fmt.Errorf("index: unknown quantization %q", config.Quantize)
+	}
+
+	switch config.IndexType {
+	case FLAT_INDEX:
+		return NewFlat(), nil
+	case HNSW_INDEX:
+		return NewHNSW(config.HNSWM, config.HNSWEfConstruction, config.HNSWEfSearch), nil
+	default:
+		return nil, fmt.Errorf("index: unknown index type %q", config.IndexType)
+	}
+}
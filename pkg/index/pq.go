@@ -0,0 +1,161 @@
+package index
+
+import "math/rand"
+
+// number of centroids per subspace, so each subspace code fits in a byte
+const PQ_CENTROIDS = 256
+
+// upper bound on the vectors used to train the codebooks
+const PQ_TRAIN_SAMPLE = 8192
+const PQ_TRAIN_ITERATIONS = 10
+
+// ProductQuantizer splits vectors into Subspaces contiguous slices and
+// replaces each slice with the index of its nearest centroid, learned by
+// k-means. A 768-dim vector with 96 subspaces is stored in 96 bytes.
+type ProductQuantizer struct {
+	Subspaces int
+	// Centroids[m][c] is centroid c of subspace m
+	Centroids [][][]float32
+}
+
+func NewProductQuantizer(subspaces int) *ProductQuantizer {
+	if subspaces < 1 {
+		subspaces = 1
+	}
+	return &ProductQuantizer{Subspaces: subspaces}
+}
+
+func (p *ProductQuantizer) Trained() bool {
+	return p.Centroids != nil
+}
+
+// bounds returns the slice of a dim-length vector belonging to subspace m.
+// Trailing dimensions that do not divide evenly go to the last subspace.
+func (p *ProductQuantizer) bounds(m int, dim int) (int, int) {
+	width := dim / p.Subspaces
+	start := m * width
+	end := start + width
+	if m == p.Subspaces-1 {
+		end = dim
+	}
+	return start, end
+}
+
+func (p *ProductQuantizer) Train(vectors [][]float32) {
+	if len(vectors) == 0 {
+		return
+	}
+	dim := len(vectors[0])
+	if p.Subspaces > dim {
+		p.Subspaces = dim
+	}
+
+	rng := rand.New(rand.NewSource(1))
+	sample := vectors
+	if len(sample) > PQ_TRAIN_SAMPLE {
+		sample = make([][]float32, PQ_TRAIN_SAMPLE)
+		for i, j := range rng.Perm(len(vectors))[:PQ_TRAIN_SAMPLE] {
+			sample[i] = vectors[j]
+		}
+	}
+
+	p.Centroids = make([][][]float32, p.Subspaces)
+	for m := range p.Centroids {
+		start, end := p.bounds(m, dim)
+		sub := make([][]float32, len(sample))
+		for i, v := range sample {
+			sub[i] = v[start:end]
+		}
+		p.Centroids[m] = kmeans(rng, sub, PQ_CENTROIDS, PQ_TRAIN_ITERATIONS)
+	}
+}
+
+func (p *ProductQuantizer) Encode(v []float32) []byte {
+	code := make([]byte, p.Subspaces)
+	for m, centroids := range p.Centroids {
+		start, end := p.bounds(m, len(v))
+		code[m] = byte(nearest(centroids, v[start:end]))
+	}
+	return code
+}
+
+func (p *ProductQuantizer) Scorer(q []float32) func(code []byte) float32 {
+	// precompute the dot product of every query slice with every centroid
+	table := make([][]float32, p.Subspaces)
+	for m, centroids := range p.Centroids {
+		start, end := p.bounds(m, len(q))
+		table[m] = make([]float32, len(centroids))
+		for c, centroid := range centroids {
+			table[m][c] = dot(q[start:end], centroid)
+		}
+	}
+	return func(code []byte) float32 {
+		var score float32
+		for m, c := range code {
+			score += table[m][c]
+		}
+		return score
+	}
+}
+
+func squaredDistance(a []float32, b []float32) float32 {
+	var sum float32
+	for i := range a {
+		d := a[i] - b[i]
+		sum += d * d
+	}
+	return sum
+}
+
+func nearest(centroids [][]float32, v []float32) int {
+	best, bestDist := 0, squaredDistance(centroids[0], v)
+	for c := 1; c < len(centroids); c++ {
+		if d := squaredDistance(centroids[c], v); d < bestDist {
+			best, bestDist = c, d
+		}
+	}
+	return best
+}
+
+// kmeans clusters vectors into at most k centroids with Lloyd's algorithm.
+func kmeans(rng *rand.Rand, vectors [][]float32, k int, iterations int) [][]float32 {
+	if k > len(vectors) {
+		k = len(vectors)
+	}
+	dim := len(vectors[0])
+	centroids := make([][]float32, k)
+	for i, j := range rng.Perm(len(vectors))[:k] {
+		centroids[i] = append([]float32(nil), vectors[j]...)
+	}
+
+	assignments := make([]int, len(vectors))
+	for it := 0; it < iterations; it++ {
+		for i, v := range vectors {
+			assignments[i] = nearest(centroids, v)
+		}
+
+		sums := make([][]float32, k)
+		counts := make([]int, k)
+		for c := range sums {
+			sums[c] = make([]float32, dim)
+		}
+		for i, v := range vectors {
+			c := assignments[i]
+			counts[c]++
+			for d, f := range v {
+				sums[c][d] += f
+			}
+		}
+		for c := range centroids {
+			if counts[c] == 0 {
+				// reseed empty clusters from a random vector
+				copy(centroids[c], vectors[rng.Intn(len(vectors))])
+				continue
+			}
+			for d := range centroids[c] {
+				centroids[c][d] = sums[c][d] / float32(counts[c])
+			}
+		}
+	}
+	return centroids
+}
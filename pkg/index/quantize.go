@@ -0,0 +1,86 @@
+package index
+
+import "math"
+
+// Quantizer compresses vectors into compact codes. Scores are computed
+// asymmetrically: the query stays at full precision and is compared directly
+// against the codes, so only stored vectors lose accuracy.
+type Quantizer interface {
+	Trained() bool
+	Train(vectors [][]float32)
+	Encode(v []float32) []byte
+	// Scorer returns a function approximating dot(q, v) for the vector v
+	// that code was encoded from.
+	Scorer(q []float32) func(code []byte) float32
+}
+
+// ScalarQuantizer maps each dimension onto 256 evenly spaced levels between
+// the minimum and maximum observed during training, storing one byte per
+// dimension instead of four.
+type ScalarQuantizer struct {
+	Min  []float32
+	Step []float32
+}
+
+func NewScalarQuantizer() *ScalarQuantizer {
+	return &ScalarQuantizer{}
+}
+
+func (s *ScalarQuantizer) Trained() bool {
+	return s.Min != nil
+}
+
+func (s *ScalarQuantizer) Train(vectors [][]float32) {
+	if len(vectors) == 0 {
+		return
+	}
+	dim := len(vectors[0])
+	lo := make([]float32, dim)
+	hi := make([]float32, dim)
+	copy(lo, vectors[0])
+	copy(hi, vectors[0])
+	for _, v := range vectors[1:] {
+		for i, f := range v {
+			if f < lo[i] {
+				lo[i] = f
+			}
+			if f > hi[i] {
+				hi[i] = f
+			}
+		}
+	}
+	s.Min = lo
+	s.Step = make([]float32, dim)
+	for i := range s.Step {
+		s.Step[i] = (hi[i] - lo[i]) / 255
+	}
+}
+
+func (s *ScalarQuantizer) Encode(v []float32) []byte {
+	code := make([]byte, len(v))
+	for i, f := range v {
+		if s.Step[i] == 0 {
+			continue
+		}
+		level := math.Round(float64((f - s.Min[i]) / s.Step[i]))
+		code[i] = byte(math.Max(0, math.Min(255, level)))
+	}
+	return code
+}
+
+func (s *ScalarQuantizer) Scorer(q []float32) func(code []byte) float32 {
+	// dot(q, v) = sum(q[i] * min[i]) + sum(q[i] * step[i] * code[i])
+	var bias float32
+	weights := make([]float32, len(q))
+	for i, f := range q {
+		bias += f * s.Min[i]
+		weights[i] = f * s.Step[i]
+	}
+	return func(code []byte) float32 {
+		score := bias
+		for i, c := range code {
+			score += weights[i] * float32(c)
+		}
+		return score
+	}
+}
@@ -0,0 +1,164 @@
+package index
+
+import (
+	"sync"
+
+	"github.com/skrider/softgrep/pkg/chunk"
+)
+
+// MIN_TRAINING_VECTORS is the number of vectors a quantizer is trained on at
+// the least. Until that many have been added, a Quantized index keeps them
+// at full precision and searches them exactly.
+const MIN_TRAINING_VECTORS = 4 * PQ_CENTROIDS
+
+// Vectors looks up the full precision vectors of indexed records.
+type Vectors interface {
+	// Vector returns the vector the record with the given id was added
+	// with, or false if it is not available.
+	Vector(id int) ([]float32, bool)
+}
+
+// Quantized is an exhaustive index over quantized codes. Vectors added
+// before the quantizer is trained are held at full precision, and once there
+// are MIN_TRAINING_VECTORS of them they are encoded in bulk when the index is
+// searched or saved; after that only the codes are kept. If rerank is
+// positive and a source of full precision vectors is set with SetVectors,
+// the best rerank candidates by approximate score are re-scored exactly.
+type Quantized struct {
+	mu        sync.RWMutex
+	quantizer Quantizer
+	rerank    int
+	source    Vectors // nil if candidates are not re-scored
+	dim       int
+	pending   [][]float32 // every vector, until the quantizer is trained
+	codes     [][]byte
+	chunks    []chunk.Chunk
+}
+
+func NewQuantized(quantizer Quantizer, rerank int) *Quantized {
+	return &Quantized{
+		quantizer: quantizer,
+		rerank:    rerank,
+	}
+}
+
+// SetVectors sets the source of the vectors candidates are re-scored with.
+// Candidates whose vector is not available keep their approximate score.
+func (x *Quantized) SetVectors(source Vectors) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.source = source
+}
+
+func (x *Quantized) Add(v []float32, c chunk.Chunk) error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if x.dim == 0 {
+		x.dim = len(v)
+	}
+	if len(v) != x.dim {
+		return DimensionMismatchError
+	}
+
+	v = normalize(v)
+	x.chunks = append(x.chunks, c)
+	if x.quantizer.Trained() {
+		x.codes = append(x.codes, x.quantizer.Encode(v))
+	} else {
+		x.pending = append(x.pending, v)
+	}
+	return nil
+}
+
+// trainable reports whether enough vectors are pending to train the
+// quantizer. It must be called with mu held.
+func (x *Quantized) trainable() bool {
+	return !x.quantizer.Trained() && len(x.pending) >= MIN_TRAINING_VECTORS
+}
+
+// flush trains the quantizer on the pending vectors and encodes them, if
+// there are enough of them. It must be called with mu held for writing.
+func (x *Quantized) flush() {
+	if !x.trainable() {
+		return
+	}
+	x.quantizer.Train(x.pending)
+	x.codes = make([][]byte, len(x.pending))
+	for id, v := range x.pending {
+		x.codes[id] = x.quantizer.Encode(v)
+	}
+	x.pending = nil
+}
+
+func (x *Quantized) Search(q []float32, k int) []Result {
+	x.mu.RLock()
+	if x.trainable() {
+		x.mu.RUnlock()
+		x.mu.Lock()
+		x.flush()
+		x.mu.Unlock()
+		x.mu.RLock()
+	}
+	defer x.mu.RUnlock()
+	if len(q) != x.dim {
+		return nil
+	}
+
+	q = normalize(q)
+	var candidates []candidate
+	if !x.quantizer.Trained() {
+		top := newTopK(k)
+		for id, v := range x.pending {
+			top.push(id, dot(q, v))
+		}
+		candidates = top.sorted()
+	} else {
+		candidates = x.search(q, k)
+	}
+
+	results := make([]Result, len(candidates))
+	for i, c := range candidates {
+		results[i] = Result{Chunk: x.chunks[c.id], Score: c.score}
+	}
+	return results
+}
+
+// search returns the k best codes for q, re-scoring the best rerank by
+// approximate score with their full precision vectors if there is a source
+// of them. It must be called with mu held.
+func (x *Quantized) search(q []float32, k int) []candidate {
+	n := k
+	if x.source != nil && x.rerank > n {
+		n = x.rerank
+	}
+	score := x.quantizer.Scorer(q)
+	top := newTopK(n)
+	for id, code := range x.codes {
+		top.push(id, score(code))
+	}
+	candidates := top.sorted()
+	if x.source == nil || x.rerank <= 0 {
+		return candidates
+	}
+
+	top = newTopK(k)
+	for _, c := range candidates {
+		if v, ok := x.source.Vector(c.id); ok && len(v) == x.dim {
+			c.score = dot(q, normalize(v))
+		}
+		top.push(c.id, c.score)
+	}
+	return top.sorted()
+}
+
+func (x *Quantized) Record(id int) chunk.Chunk {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	return x.chunks[id]
+}
+
+func (x *Quantized) Len() int {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	return len(x.chunks)
+}
@@ -0,0 +1,129 @@
+package index
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/skrider/softgrep/pkg/chunk"
+)
+
+// vectorList is a source of the vectors a test added, by id.
+type vectorList [][]float32
+
+func (l vectorList) Vector(id int) ([]float32, bool) {
+	return l[id], true
+}
+
+func TestQuantizedRecall(t *testing.T) {
+	const n, dim, k, queries = 2000, 64, 10, 20
+	rng := rand.New(rand.NewSource(3))
+	vectors := randomVectors(rng, n, dim)
+
+	cases := []struct {
+		name      string
+		quantizer Quantizer
+		rerank    int
+		min       float64
+	}{
+		{"sq8", NewScalarQuantizer(), 0, 0.8},
+		{"sq8-rerank", NewScalarQuantizer(), 50, 0.99},
+		{"pq-rerank", NewProductQuantizer(16), 200, 0.9},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			flat := NewFlat()
+			x := NewQuantized(c.quantizer, c.rerank)
+			x.SetVectors(vectorList(vectors))
+			for i, v := range vectors {
+				c := chunk.Chunk{Content: fmt.Sprint(i)}
+				if err := flat.Add(v, c); err != nil {
+					t.Fatal(err)
+				}
+				if err := x.Add(v, c); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			total := 0.0
+			for _, q := range randomVectors(rng, queries, dim) {
+				total += recall(flat.Search(q, k), x.Search(q, k))
+			}
+			if r := total / queries; r < c.min {
+				t.Errorf("recall@%d = %.3f, want >= %.2f", k, r, c.min)
+			}
+
+			var buf bytes.Buffer
+			if err := Save(&buf, x); err != nil {
+				t.Fatal(err)
+			}
+			// the vectors are not saved with the codes
+			size := buf.Len()
+			var flatBuf bytes.Buffer
+			if err := Save(&flatBuf, flat); err != nil {
+				t.Fatal(err)
+			}
+			if size >= flatBuf.Len()/2 {
+				t.Errorf("expected the quantized index to be less than half the size of the flat one, got %d and %d bytes", size, flatBuf.Len())
+			}
+
+			loaded, err := Load(&buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			loaded.(*Quantized).SetVectors(vectorList(vectors))
+			q := randomVectors(rng, 1, dim)[0]
+			want, got := x.Search(q, k), loaded.Search(q, k)
+			for i := range want {
+				if want[i] != got[i] {
+					t.Fatalf("result %d differs after load: %v != %v", i, want[i], got[i])
+				}
+			}
+		})
+	}
+}
+
+// TestQuantizedSmall checks that an index too small to train the quantizer
+// on is searched exactly, before and after it is saved.
+func TestQuantizedSmall(t *testing.T) {
+	const n, dim, k = MIN_TRAINING_VECTORS - 1, 16, 10
+	rng := rand.New(rand.NewSource(5))
+	flat := NewFlat()
+	x := NewQuantized(NewScalarQuantizer(), 0)
+	for i, v := range randomVectors(rng, n, dim) {
+		c := chunk.Chunk{Content: fmt.Sprint(i)}
+		if err := flat.Add(v, c); err != nil {
+			t.Fatal(err)
+		}
+		if err := x.Add(v, c); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := Save(&buf, x); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := Load(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, q := range randomVectors(rng, 5, dim) {
+		want := flat.Search(q, k)
+		for _, idx := range []Index{x, loaded} {
+			got := idx.Search(q, k)
+			if len(got) != len(want) {
+				t.Fatalf("expected %d results, got %d", len(want), len(got))
+			}
+			for i := range want {
+				if want[i] != got[i] {
+					t.Fatalf("result %d differs from the flat index: %v != %v", i, want[i], got[i])
+				}
+			}
+		}
+	}
+	if x.quantizer.Trained() {
+		t.Errorf("expected the quantizer to wait for %d vectors", MIN_TRAINING_VECTORS)
+	}
+}
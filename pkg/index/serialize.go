@@ -0,0 +1,203 @@
+package index
+
+import (
+	"encoding/gob"
+	"fmt"
+	"io"
+	"math/rand"
+
+	"github.com/skrider/softgrep/pkg/chunk"
+)
+
+func init() {
+	gob.Register(&ScalarQuantizer{})
+	gob.Register(&ProductQuantizer{})
+}
+
+type header struct {
+	Type string
+}
+
+type flatSnapshot struct {
+	Dim     int
+	Vectors []float32
+	Chunks  []chunk.Chunk
+}
+
+type hnswSnapshot struct {
+	M              int
+	EfConstruction int
+	EfSearch       int
+	Dim            int
+	Vectors        [][]float32
+	Chunks         []chunk.Chunk
+	Friends        [][][]int32
+	Entry          int
+	MaxLevel       int
+}
+
+type quantizedSnapshot struct {
+	Quantizer Quantizer
+	Rerank    int
+	Dim       int
+	Codes     [][]byte
+	// the vectors of an index too small to train the quantizer on; older
+	// indexes also kept them once trained, for re-scoring
+	Vectors [][]float32
+	Chunks  []chunk.Chunk
+}
+
+// Save writes idx to w in a format understood by Load.
+func Save(w io.Writer, idx Index) error {
+	enc := gob.NewEncoder(w)
+	switch idx := idx.(type) {
+	case *Flat:
+		idx.mu.RLock()
+		defer idx.mu.RUnlock()
+		if err := enc.Encode(header{Type: FLAT_INDEX}); err != nil {
+			return err
+		}
+		return enc.Encode(flatSnapshot{
+			Dim:     idx.dim,
+			Vectors: idx.vectors,
+			Chunks:  idx.chunks,
+		})
+	case *HNSW:
+		idx.mu.RLock()
+		defer idx.mu.RUnlock()
+		if err := enc.Encode(header{Type: HNSW_INDEX}); err != nil {
+			return err
+		}
+		return enc.Encode(hnswSnapshot{
+			M:              idx.m,
+			EfConstruction: idx.efConstruction,
+			EfSearch:       idx.efSearch,
+			Dim:            idx.dim,
+			Vectors:        idx.vectors,
+			Chunks:         idx.chunks,
+			Friends:        idx.friends,
+			Entry:          idx.entry,
+			MaxLevel:       idx.maxLevel,
+		})
+	case *Quantized:
+		idx.mu.Lock()
+		defer idx.mu.Unlock()
+		idx.flush()
+		if err := enc.Encode(header{Type: QUANTIZED_INDEX}); err != nil {
+			return err
+		}
+		return enc.Encode(quantizedSnapshot{
+			Quantizer: idx.quantizer,
+			Rerank:    idx.rerank,
+			Dim:       idx.dim,
+			Codes:     idx.codes,
+			Vectors:   idx.pending,
+			Chunks:    idx.chunks,
+		})
+	default:
+		return fmt.Errorf("index: cannot save index of type %T", idx)
+	}
+}
+
+// Load reads an index previously written by Save.
+func Load(r io.Reader) (Index, error) {
+	dec := gob.NewDecoder(r)
+	var h header
+	if err := dec.Decode(&h); err != nil {
+		return nil, err
+	}
+	switch h.Type {
+	case FLAT_INDEX:
+		var s flatSnapshot
+		if err := dec.Decode(&s); err != nil {
+			return nil, err
+		}
+		return &Flat{
+			dim:     s.Dim,
+			vectors: s.Vectors,
+			chunks:  s.Chunks,
+		}, nil
+	case HNSW_INDEX:
+		var s hnswSnapshot
+		if err := dec.Decode(&s); err != nil {
+			return nil, err
+		}
+		h := NewHNSW(s.M, s.EfConstruction, s.EfSearch)
+		h.dim = s.Dim
+		h.vectors = s.Vectors
+		h.chunks = s.Chunks
+		h.friends = s.Friends
+		h.entry = s.Entry
+		h.maxLevel = s.MaxLevel
+		h.rng = rand.New(rand.NewSource(int64(len(s.Vectors))))
+		return h, nil
+	case QUANTIZED_INDEX:
+		var s quantizedSnapshot
+		if err := dec.Decode(&s); err != nil {
+			return nil, err
+		}
+		x := NewQuantized(s.Quantizer, s.Rerank)
+		x.dim = s.Dim
+		x.codes = s.Codes
+		if !x.quantizer.Trained() {
+			x.pending = s.Vectors
+		}
+		x.chunks = s.Chunks
+		return x, nil
+	default:
+		return nil, fmt.Errorf("index: unknown index type %q", h.Type)
+	}
+}
+
+type bm25Snapshot struct {
+	Postings map[string][]posting
+	Lengths  []int32
+	Docs     int
+	Total    int64
+}
+
+// SaveBM25 writes b to w in a format understood by LoadBM25. The records
+// of b are not written.
+func SaveBM25(w io.Writer, b *BM25) error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return gob.NewEncoder(w).Encode(bm25Snapshot{
+		Postings: b.postings,
+		Lengths:  b.lengths,
+		Docs:     b.docs,
+		Total:    b.total,
+	})
+}
+
+// LoadBM25 reads an index previously written by SaveBM25, whose results
+// are looked up in records.
+func LoadBM25(r io.Reader, records Records) (*BM25, error) {
+	var s bm25Snapshot
+	if err := gob.NewDecoder(r).Decode(&s); err != nil {
+		return nil, err
+	}
+	b := NewBM25(records)
+	if s.Postings != nil {
+		b.postings = s.Postings
+	}
+	b.lengths = s.Lengths
+	b.docs = s.Docs
+	b.total = s.Total
+	return b, nil
+}
+
+// SaveChunkList writes l to w in a format understood by LoadChunkList.
+func SaveChunkList(w io.Writer, l *ChunkList) error {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return gob.NewEncoder(w).Encode(l.chunks)
+}
+
+// LoadChunkList reads a list previously written by SaveChunkList.
+func LoadChunkList(r io.Reader) (*ChunkList, error) {
+	l := NewChunkList()
+	if err := gob.NewDecoder(r).Decode(&l.chunks); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
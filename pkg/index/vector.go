@@ -0,0 +1,25 @@
+package index
+
+import "math"
+
+func dot(a []float32, b []float32) float32 {
+	var sum float32
+	for i := range a {
+		sum += a[i] * b[i]
+	}
+	return sum
+}
+
+// normalize returns a copy of v scaled to unit length, so that cosine
+// similarity reduces to a dot product.
+func normalize(v []float32) []float32 {
+	out := make([]float32, len(v))
+	norm := float32(math.Sqrt(float64(dot(v, v))))
+	if norm == 0 {
+		return out
+	}
+	for i, f := range v {
+		out[i] = f / norm
+	}
+	return out
+}
@@ -0,0 +1,155 @@
+package output
+
+import (
+	"encoding/json"
+	"io"
+	"time"
+
+	"github.com/skrider/softgrep/pkg/index"
+)
+
+// JSONPrinter writes one JSON object per line, modeled on rg --json. Every
+// message has a "type" and a "data" field:
+//
+//	{"type":"begin","data":{"path":"pkg/index/flat.go"}}
+//	{"type":"match","data":{"path":"pkg/index/flat.go","rank":1,"score":0.8123,
+//	  "lines":{"start":34,"end":52},"bytes":{"start":812,"end":1390},
+//	  "column":1,"language":"golang","kind":"function","snippet":"func (f *Flat) ..."}}
+//	{"type":"end","data":{"path":"pkg/index/flat.go","stats":{"matches":1}}}
+//	{"type":"summary","data":{"elapsed":{"secs":1,"nanos":500000000,"human":"1.5s"},
+//	  "stats":{"matches":10,"files":4}}}
+//
+// Results are printed best first. A begin message precedes each run of
+// consecutive matches from the same file and an end message follows it, so
+// a file may appear in more than one begin/end pair. Line numbers are one
+// based and inclusive, byte offsets are zero based and end exclusive, and
+// column is the one based byte column of the first matched line. kind is the
+// name of the tree-sitter query that produced the chunk, or "window" for
+// files split into strided windows. The summary is always the last message.
+type JSONPrinter struct {
+	enc   *json.Encoder
+	start time.Time
+
+	path    string
+	open    bool
+	matches int
+	inFile  int
+	files   map[string]bool
+}
+
+type jsonMessage struct {
+	Type string      `json:"type"`
+	Data interface{} `json:"data"`
+}
+
+type jsonRange struct {
+	Start uint32 `json:"start"`
+	End   uint32 `json:"end"`
+}
+
+type jsonBegin struct {
+	Path string `json:"path"`
+}
+
+type jsonMatch struct {
+	Path     string    `json:"path"`
+	Rank     int       `json:"rank"`
+	Score    float32   `json:"score"`
+	Lines    jsonRange `json:"lines"`
+	Bytes    jsonRange `json:"bytes"`
+	Column   uint32    `json:"column"`
+	Language string    `json:"language"`
+	Kind     string    `json:"kind"`
+	Snippet  string    `json:"snippet"`
+}
+
+type jsonFileStats struct {
+	Matches int `json:"matches"`
+}
+
+type jsonEnd struct {
+	Path  string        `json:"path"`
+	Stats jsonFileStats `json:"stats"`
+}
+
+type jsonElapsed struct {
+	Secs  int64  `json:"secs"`
+	Nanos int64  `json:"nanos"`
+	Human string `json:"human"`
+}
+
+type jsonStats struct {
+	Matches int `json:"matches"`
+	Files   int `json:"files"`
+}
+
+type jsonSummary struct {
+	Elapsed jsonElapsed `json:"elapsed"`
+	Stats   jsonStats   `json:"stats"`
+}
+
+func NewJSONPrinter(w io.Writer) *JSONPrinter {
+	return &JSONPrinter{
+		enc:   json.NewEncoder(w),
+		start: time.Now(),
+		files: make(map[string]bool),
+	}
+}
+
+func (p *JSONPrinter) emit(kind string, data interface{}) error {
+	return p.enc.Encode(jsonMessage{Type: kind, Data: data})
+}
+
+func (p *JSONPrinter) end() error {
+	if !p.open {
+		return nil
+	}
+	p.open = false
+	return p.emit("end", jsonEnd{Path: p.path, Stats: jsonFileStats{Matches: p.inFile}})
+}
+
+func (p *JSONPrinter) Print(r index.Result) error {
+	if !p.open || r.Path != p.path {
+		if err := p.end(); err != nil {
+			return err
+		}
+		if err := p.emit("begin", jsonBegin{Path: r.Path}); err != nil {
+			return err
+		}
+		p.path, p.open, p.inFile = r.Path, true, 0
+	}
+	p.matches++
+	p.inFile++
+	p.files[r.Path] = true
+
+	kind := r.Query
+	if kind == "" {
+		kind = "window"
+	}
+	return p.emit("match", jsonMatch{
+		Path:     r.Path,
+		Rank:     p.matches,
+		Score:    r.Score,
+		Lines:    jsonRange{Start: r.Start.Row + 1, End: lastRow(r) + 1},
+		Bytes:    jsonRange{Start: r.StartByte, End: r.EndByte},
+		Column:   r.Start.Column + 1,
+		Language: r.Language,
+		Kind:     kind,
+		Snippet:  r.Content,
+	})
+}
+
+func (p *JSONPrinter) Close() error {
+	if err := p.end(); err != nil {
+		return err
+	}
+	elapsed := time.Since(p.start)
+	return p.emit("summary", jsonSummary{
+		Elapsed: jsonElapsed{
+			Secs:  int64(elapsed / time.Second),
+			Nanos: int64(elapsed % time.Second),
+			Human: elapsed.String(),
+		},
+		Stats: jsonStats{Matches: p.matches, Files: len(p.files)},
+	})
+}
@@ -0,0 +1,95 @@
+package output
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/skrider/softgrep/pkg/index"
+)
+
+// normalizeJSON re-encodes every line of out with sorted keys, and drops the
+// elapsed time of the summary after checking its fields, so that output can
+// be compared against fixed lines.
+func normalizeJSON(t *testing.T, out string) []string {
+	var lines []string
+	for _, line := range strings.Split(strings.TrimSuffix(out, "\n"), "\n") {
+		var m map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &m); err != nil {
+			t.Fatalf("invalid JSON line %q: %v", line, err)
+		}
+		if m["type"] == "summary" {
+			data := m["data"].(map[string]interface{})
+			elapsed, _ := data["elapsed"].(map[string]interface{})
+			for _, field := range []string{"secs", "nanos", "human"} {
+				if _, ok := elapsed[field]; !ok {
+					t.Errorf("summary elapsed has no %s field: %s", field, line)
+				}
+			}
+			delete(data, "elapsed")
+		}
+		b, err := json.Marshal(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, string(b))
+	}
+	return lines
+}
+
+func TestJSONPrinter(t *testing.T) {
+	const (
+		beginA = `{"data":{"path":"a.go"},"type":"begin"}`
+		beginB = `{"data":{"path":"b.go"},"type":"begin"}`
+		endB   = `{"data":{"path":"b.go","stats":{"matches":1}},"type":"end"}`
+	)
+	cases := []struct {
+		name    string
+		results []index.Result
+		want    []string
+	}{
+		{"empty", nil, []string{
+			`{"data":{"stats":{"files":0,"matches":0}},"type":"summary"}`,
+		}},
+		{"runs", []index.Result{resultOne, resultEnd, resultY}, []string{
+			beginA,
+			`{"data":{"bytes":{"end":40,"start":11},"column":1,"kind":"function","language":"golang","lines":{"end":5,"start":3},"path":"a.go","rank":1,"score":0.5,"snippet":"func One() int {\n\treturn 1\n}\n"},"type":"match"}`,
+			`{"data":{"bytes":{"end":46,"start":40},"column":1,"kind":"window","language":"","lines":{"end":6,"start":6},"path":"a.go","rank":2,"score":0.125,"snippet":"// end"},"type":"match"}`,
+			`{"data":{"path":"a.go","stats":{"matches":2}},"type":"end"}`,
+			beginB,
+			`{"data":{"bytes":{"end":4,"start":2},"column":1,"kind":"window","language":"","lines":{"end":2,"start":2},"path":"b.go","rank":3,"score":0.25,"snippet":"y\n"},"type":"match"}`,
+			endB,
+			`{"data":{"stats":{"files":2,"matches":3}},"type":"summary"}`,
+		}},
+		// a file interrupted by another gets a second begin/end pair but is
+		// counted once
+		{"revisit", []index.Result{resultOne, resultY, resultEnd}, []string{
+			beginA,
+			`{"data":{"bytes":{"end":40,"start":11},"column":1,"kind":"function","language":"golang","lines":{"end":5,"start":3},"path":"a.go","rank":1,"score":0.5,"snippet":"func One() int {\n\treturn 1\n}\n"},"type":"match"}`,
+			`{"data":{"path":"a.go","stats":{"matches":1}},"type":"end"}`,
+			beginB,
+			`{"data":{"bytes":{"end":4,"start":2},"column":1,"kind":"window","language":"","lines":{"end":2,"start":2},"path":"b.go","rank":2,"score":0.25,"snippet":"y\n"},"type":"match"}`,
+			endB,
+			beginA,
+			`{"data":{"bytes":{"end":46,"start":40},"column":1,"kind":"window","language":"","lines":{"end":6,"start":6},"path":"a.go","rank":3,"score":0.125,"snippet":"// end"},"type":"match"}`,
+			`{"data":{"path":"a.go","stats":{"matches":1}},"type":"end"}`,
+			`{"data":{"stats":{"files":2,"matches":3}},"type":"summary"}`,
+		}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			printAll(t, NewJSONPrinter(&buf), c.results)
+			got := normalizeJSON(t, buf.String())
+			if len(got) != len(c.want) {
+				t.Fatalf("got %d lines, want %d:\n%s", len(got), len(c.want), strings.Join(got, "\n"))
+			}
+			for i := range got {
+				if got[i] != c.want[i] {
+					t.Errorf("line %d:\ngot  %s\nwant %s", i+1, got[i], c.want[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,76 @@
+package output
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/skrider/softgrep/pkg/config"
+	"github.com/skrider/softgrep/pkg/index"
+)
+
+// Printer writes search results in some output format.
+type Printer interface {
+	Print(r index.Result) error
+	// Close flushes any output that is only written once all results are known.
+	Close() error
+}
+
+const STANDARD = "standard"
+const JSON = "json"
+const VIMGREP = "vimgrep"
+
+// lastRow returns the last row r covers. A chunk ending in a newline ends at
+// column 0 of the following row, which is not part of the chunk.
+func lastRow(r index.Result) uint32 {
+	if r.End.Column == 0 && r.End.Row > r.Start.Row {
+		return r.End.Row - 1
+	}
+	return r.End.Row
+}
+
+// New returns the printer selected by config.
+func New(w io.Writer, config *config.Config) (Printer, error) {
+	for _, setting := range []string{config.Color, config.Heading} {
+		if setting != AUTO && setting != ALWAYS && setting != NEVER {
+			return nil, fmt.Errorf("output: expected one of auto, always or never, got %q", setting)
+		}
+	}
+	switch config.Format {
+	case STANDARD:
+		return NewStandardPrinter(w, config), nil
+	case JSON:
+		return NewJSONPrinter(w), nil
+	case VIMGREP:
+		return NewVimgrepPrinter(w), nil
+	default:
+		return nil, fmt.Errorf("output: unknown output format %q", config.Format)
+	}
+}
+
+// fileCache holds the lines of files that results were found in.
+type fileCache struct {
+	files map[string][]string
+}
+
+func newFileCache() *fileCache {
+	return &fileCache{files: make(map[string][]string)}
+}
+
+// lines returns the lines of the file r came from, falling back to the lines
+// of r itself if the file cannot be read, e.g. when it came from stdin. The
+// second return value is the row of the first line returned.
+func (c *fileCache) lines(r index.Result) ([]string, uint32) {
+	if lines, ok := c.files[r.Path]; ok {
+		return lines, 0
+	}
+	if r.Path != "-" {
+		if b, err := os.ReadFile(r.Path); err == nil {
+			lines := strings.Split(strings.TrimSuffix(string(b), "\n"), "\n")
+			c.files[r.Path] = lines
+			return lines, 0
+		}
+	}
+	return strings.Split(strings.TrimSuffix(r.Content, "\n"), "\n"), r.Start.Row
+}
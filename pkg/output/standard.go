@@ -0,0 +1,138 @@
+package output
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/skrider/softgrep/pkg/config"
+	"github.com/skrider/softgrep/pkg/index"
+)
+
+// ANSI colors matching ripgrep's defaults
+const COLOR_PATH = "\x1b[35m"
+const COLOR_LINE = "\x1b[32m"
+const COLOR_SCORE = "\x1b[33m"
+const COLOR_RESET = "\x1b[0m"
+
+const AUTO = "auto"
+const ALWAYS = "always"
+const NEVER = "never"
+
+// StandardPrinter prints results the way ripgrep does. Matching lines are
+// numbered with a ':' separator followed by the similarity score, context
+// lines with a '-' separator. With headings, the path is printed once above
+// consecutive results from the same file, otherwise it prefixes every line.
+type StandardPrinter struct {
+	w       io.Writer
+	color   bool
+	heading bool
+	before  int
+	after   int
+
+	files    *fileCache
+	lastPath string
+	printed  bool
+}
+
+func NewStandardPrinter(w io.Writer, config *config.Config) *StandardPrinter {
+	return &StandardPrinter{
+		w:       w,
+		color:   enabled(config.Color, w),
+		heading: enabled(config.Heading, w),
+		before:  config.Before,
+		after:   config.After,
+		files:   newFileCache(),
+	}
+}
+
+// enabled resolves an auto/always/never setting, where auto means enabled
+// only when writing to a terminal.
+func enabled(setting string, w io.Writer) bool {
+	switch setting {
+	case ALWAYS:
+		return true
+	case NEVER:
+		return false
+	default:
+		return isTerminal(w)
+	}
+}
+
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+func (p *StandardPrinter) paint(color string, s string) string {
+	if !p.color {
+		return s
+	}
+	return color + s + COLOR_RESET
+}
+
+func (p *StandardPrinter) Print(r index.Result) error {
+	lines, offset := p.files.lines(r)
+
+	first, last := r.Start.Row, lastRow(r)
+	from, to := int(first)-p.before, int(last)+p.after
+	if from < int(offset) {
+		from = int(offset)
+	}
+	if end := int(offset) + len(lines) - 1; to > end {
+		to = end
+	}
+
+	if p.heading {
+		if r.Path != p.lastPath {
+			if p.printed {
+				fmt.Fprintln(p.w)
+			}
+			fmt.Fprintln(p.w, p.paint(COLOR_PATH, r.Path))
+		} else if p.before > 0 || p.after > 0 {
+			fmt.Fprintln(p.w, "--")
+		}
+	} else if p.printed && (p.before > 0 || p.after > 0) {
+		fmt.Fprintln(p.w, "--")
+	}
+	p.lastPath = r.Path
+	p.printed = true
+
+	for row := from; row <= to; row++ {
+		line := lines[row-int(offset)]
+		number := p.paint(COLOR_LINE, fmt.Sprint(row+1))
+		match := row >= int(first) && row <= int(last)
+
+		var prefix string
+		if !p.heading {
+			prefix = p.paint(COLOR_PATH, r.Path)
+		}
+		var err error
+		switch {
+		case match && p.heading:
+			_, err = fmt.Fprintf(p.w, "%s:%s:%s\n", number, p.score(r.Score), line)
+		case match:
+			_, err = fmt.Fprintf(p.w, "%s:%s:%s:%s\n", prefix, number, p.score(r.Score), line)
+		case p.heading:
+			_, err = fmt.Fprintf(p.w, "%s-%s\n", number, line)
+		default:
+			_, err = fmt.Fprintf(p.w, "%s-%s-%s\n", prefix, number, line)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *StandardPrinter) score(s float32) string {
+	return p.paint(COLOR_SCORE, fmt.Sprintf("%.4f", s))
+}
+
+func (p *StandardPrinter) Close() error {
+	return nil
+}
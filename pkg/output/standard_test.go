@@ -0,0 +1,148 @@
+package output
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/skrider/softgrep/pkg/chunk"
+	"github.com/skrider/softgrep/pkg/config"
+	"github.com/skrider/softgrep/pkg/index"
+)
+
+// files the test results come from, written to a temporary working
+// directory by chdirFiles
+var files = map[string]string{
+	"a.go": "package a\n\nfunc One() int {\n\treturn 1\n}\n// end\n",
+	"b.go": "x\ny\nz\n",
+}
+
+var (
+	// a multi-line chunk ending in a newline, so it ends on column 0 of the
+	// row after its last line
+	resultOne = index.Result{Chunk: chunk.Chunk{
+		Path: "a.go", Content: "func One() int {\n\treturn 1\n}\n",
+		StartByte: 11, EndByte: 40, Start: chunk.Point{Row: 2}, End: chunk.Point{Row: 5},
+		Language: "golang", Query: "function",
+	}, Score: 0.5}
+	resultEnd = index.Result{Chunk: chunk.Chunk{
+		Path: "a.go", Content: "// end",
+		StartByte: 40, EndByte: 46, Start: chunk.Point{Row: 5}, End: chunk.Point{Row: 5, Column: 6},
+	}, Score: 0.125}
+	resultY = index.Result{Chunk: chunk.Chunk{
+		Path: "b.go", Content: "y\n",
+		StartByte: 2, EndByte: 4, Start: chunk.Point{Row: 1}, End: chunk.Point{Row: 2},
+	}, Score: 0.25}
+	// read from stdin, so only the chunk's own lines can be printed
+	resultStdin = index.Result{Chunk: chunk.Chunk{
+		Path: "-", Content: "q\nr\n",
+		StartByte: 30, EndByte: 34, Start: chunk.Point{Row: 10}, End: chunk.Point{Row: 12},
+	}, Score: 0.25}
+)
+
+// chdirFiles writes files to a temporary directory and makes it the working
+// directory for the rest of the test, so that results have relative paths.
+func chdirFiles(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+}
+
+func printAll(t *testing.T, p Printer, results []index.Result) {
+	for _, r := range results {
+		if err := p.Print(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStandardPrinter(t *testing.T) {
+	chdirFiles(t)
+	all := []index.Result{resultOne, resultEnd, resultY}
+	cases := []struct {
+		name          string
+		color         string
+		heading       string
+		before, after int
+		results       []index.Result
+		want          string
+	}{
+		{"plain", NEVER, NEVER, 0, 0, all, "" +
+			"a.go:3:0.5000:func One() int {\n" +
+			"a.go:4:0.5000:\treturn 1\n" +
+			"a.go:5:0.5000:}\n" +
+			"a.go:6:0.1250:// end\n" +
+			"b.go:2:0.2500:y\n"},
+		{"context", NEVER, NEVER, 1, 1, all, "" +
+			"a.go-2-\n" +
+			"a.go:3:0.5000:func One() int {\n" +
+			"a.go:4:0.5000:\treturn 1\n" +
+			"a.go:5:0.5000:}\n" +
+			"a.go-6-// end\n" +
+			"--\n" +
+			"a.go-5-}\n" +
+			"a.go:6:0.1250:// end\n" +
+			"--\n" +
+			"b.go-1-x\n" +
+			"b.go:2:0.2500:y\n" +
+			"b.go-3-z\n"},
+		{"heading", NEVER, ALWAYS, 0, 0, all, "" +
+			"a.go\n" +
+			"3:0.5000:func One() int {\n" +
+			"4:0.5000:\treturn 1\n" +
+			"5:0.5000:}\n" +
+			"6:0.1250:// end\n" +
+			"\n" +
+			"b.go\n" +
+			"2:0.2500:y\n"},
+		{"heading-context", NEVER, ALWAYS, 0, 1, all, "" +
+			"a.go\n" +
+			"3:0.5000:func One() int {\n" +
+			"4:0.5000:\treturn 1\n" +
+			"5:0.5000:}\n" +
+			"6-// end\n" +
+			"--\n" +
+			"6:0.1250:// end\n" +
+			"\n" +
+			"b.go\n" +
+			"2:0.2500:y\n" +
+			"3-z\n"},
+		{"color", ALWAYS, NEVER, 0, 0, []index.Result{resultY},
+			COLOR_PATH + "b.go" + COLOR_RESET + ":" + COLOR_LINE + "2" + COLOR_RESET + ":" +
+				COLOR_SCORE + "0.2500" + COLOR_RESET + ":y\n"},
+		{"color-heading", ALWAYS, ALWAYS, 0, 0, []index.Result{resultY},
+			COLOR_PATH + "b.go" + COLOR_RESET + "\n" +
+				COLOR_LINE + "2" + COLOR_RESET + ":" + COLOR_SCORE + "0.2500" + COLOR_RESET + ":y\n"},
+		// a buffer is not a terminal
+		{"auto", AUTO, AUTO, 0, 0, []index.Result{resultY}, "b.go:2:0.2500:y\n"},
+		{"stdin", NEVER, NEVER, 1, 1, []index.Result{resultStdin}, "" +
+			"-:11:0.2500:q\n" +
+			"-:12:0.2500:r\n"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			cfg := config.NewConfig()
+			cfg.Color, cfg.Heading, cfg.Before, cfg.After = c.color, c.heading, c.before, c.after
+			var buf bytes.Buffer
+			printAll(t, NewStandardPrinter(&buf, &cfg), c.results)
+			if got := buf.String(); got != c.want {
+				t.Errorf("got\n%s\nwant\n%s", got, c.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,36 @@
+package output
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/skrider/softgrep/pkg/index"
+)
+
+// VimgrepPrinter prints the first line of each result as path:line:column:text,
+// the format understood by Vim's quickfix list and Emacs' grep-mode.
+type VimgrepPrinter struct {
+	w     io.Writer
+	files *fileCache
+}
+
+func NewVimgrepPrinter(w io.Writer) *VimgrepPrinter {
+	return &VimgrepPrinter{
+		w:     w,
+		files: newFileCache(),
+	}
+}
+
+func (p *VimgrepPrinter) Print(r index.Result) error {
+	lines, offset := p.files.lines(r)
+	var text string
+	if row := int(r.Start.Row) - int(offset); row >= 0 && row < len(lines) {
+		text = lines[row]
+	}
+	_, err := fmt.Fprintf(p.w, "%s:%d:%d:%s\n", r.Path, r.Start.Row+1, r.Start.Column+1, text)
+	return err
+}
+
+func (p *VimgrepPrinter) Close() error {
+	return nil
+}
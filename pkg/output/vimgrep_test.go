@@ -0,0 +1,45 @@
+package output
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/skrider/softgrep/pkg/chunk"
+	"github.com/skrider/softgrep/pkg/index"
+)
+
+func TestVimgrepPrinter(t *testing.T) {
+	chdirFiles(t)
+	cases := []struct {
+		name    string
+		results []index.Result
+		want    string
+	}{
+		// only the first line of a multi-line result is printed
+		{"multi-line", []index.Result{resultOne, resultEnd, resultY}, "" +
+			"a.go:3:1:func One() int {\n" +
+			"a.go:6:1:// end\n" +
+			"b.go:2:1:y\n"},
+		{"column", []index.Result{{Chunk: chunk.Chunk{
+			Path: "a.go", Content: "return 1", Start: chunk.Point{Row: 3, Column: 1}, End: chunk.Point{Row: 3, Column: 9},
+		}}}, "a.go:4:2:\treturn 1\n"},
+		{"stdin", []index.Result{resultStdin}, "-:11:1:q\n"},
+		// a file that cannot be read falls back to the chunk's own lines
+		{"missing", []index.Result{{Chunk: chunk.Chunk{
+			Path: "gone.go", Content: "func Gone() {}\n", Start: chunk.Point{Row: 7, Column: 4}, End: chunk.Point{Row: 8},
+		}}}, "gone.go:8:5:func Gone() {}\n"},
+		// a file that shrank since it was indexed prints no text
+		{"stale", []index.Result{{Chunk: chunk.Chunk{
+			Path: "b.go", Content: "w\n", Start: chunk.Point{Row: 20}, End: chunk.Point{Row: 21},
+		}}}, "b.go:21:1:\n"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			printAll(t, NewVimgrepPrinter(&buf), c.results)
+			if got := buf.String(); got != c.want {
+				t.Errorf("got\n%s\nwant\n%s", got, c.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,742 @@
+// Package softgrep indexes files and searches them for semantic queries. It
+// is the library behind the softgrep command.
+//
+//	ix, err := softgrep.NewIndexer(softgrep.Options{})
+//	if err != nil {
+//		return err
+//	}
+//	defer ix.Close()
+//	if err := ix.Index(ctx, []string{"."}); err != nil {
+//		return err
+//	}
+//	results, err := ix.Searcher().Search(ctx, "parse a config file", 10)
+package softgrep
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"sync"
+
+	"github.com/skrider/softgrep/pkg/cache"
+	"github.com/skrider/softgrep/pkg/chunk"
+	"github.com/skrider/softgrep/pkg/config"
+	"github.com/skrider/softgrep/pkg/embed"
+	"github.com/skrider/softgrep/pkg/index"
+	"github.com/skrider/softgrep/pkg/tokenize"
+	"github.com/skrider/softgrep/pkg/walker"
+	"golang.org/x/sync/errgroup"
+)
+
+// search modes selectable with config.Mode
+const SEMANTIC_MODE = "semantic"
+const LEXICAL_MODE = "lexical"
+const HYBRID_MODE = "hybrid"
+
+// HYBRID_CANDIDATES is the number of results taken from each ranking for
+// hybrid search to fuse, unless more are asked for.
+const HYBRID_CANDIDATES = 100
+
+// NUM_WORKERS leaves a core for the rest of the pipeline, but every stage
+// needs at least one worker to make progress.
+var NUM_WORKERS = maxInt(runtime.NumCPU()-1, 1)
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// Result is a chunk of a file along with its similarity to the query.
+type Result = index.Result
+
+// Options configures an Indexer or Searcher.
+type Options struct {
+	// Config holds the chunking, inference server, cache and index
+	// settings. A nil Config means config.NewConfig().
+	Config *config.Config
+	// Logf reports problems with individual files, which are skipped.
+	// Defaults to log.Printf.
+	Logf func(format string, v ...interface{})
+}
+
+func (o *Options) setDefaults() {
+	if o.Config == nil {
+		c := config.NewConfig()
+		o.Config = &c
+	}
+	if o.Logf == nil {
+		o.Logf = log.Printf
+	}
+}
+
+// newEmbedder returns the embedder selected by config.Backend. The returned
+// closer, if not nil, releases its connections and must be closed.
+func newEmbedder(config *config.Config, logf func(format string, v ...interface{})) (embed.Embedder, io.Closer, error) {
+	if config.Backend == embed.BACKEND_STATIC {
+		if config.StaticModel == "" {
+			logf("Warning: the static backend has no model file, so results only match tokens shared with the query")
+		}
+		embedder, err := embed.NewStaticEmbedder(config.StaticModel)
+		return embedder, nil, err
+	}
+	client, conn, err := embed.Connect(config)
+	if err != nil {
+		return nil, nil, fmt.Errorf("connecting to inference server: %w", err)
+	}
+	embedder := embed.NewTritonEmbedder(client, config.Model, config.ModelVersion)
+	embedder.SetRetrier(embed.NewRetrier(config))
+	return embedder, conn, nil
+}
+
+// newCrossEncoder returns the cross-encoder selected by config, or nil if
+// there is none. It runs on the inference server embedder talks to.
+func newCrossEncoder(config *config.Config, embedder embed.Embedder) (*embed.CrossEncoder, error) {
+	if config.CrossEncoder == "" {
+		return nil, nil
+	}
+	triton, ok := embedder.(*embed.TritonEmbedder)
+	if !ok {
+		return nil, fmt.Errorf("the cross-encoder runs on the inference server, so it needs the %s backend", embed.BACKEND_TRITON)
+	}
+	return triton.CrossEncoder(config.CrossEncoder, ""), nil
+}
+
+// Indexer chunks, embeds and indexes files. An Indexer may index several
+// sets of paths, and may be searched in between.
+type Indexer struct {
+	config   *config.Config
+	logf     func(format string, v ...interface{})
+	idx      index.Index      // nil in lexical mode
+	chunks   *index.ChunkList // the records of lexical, in lexical mode
+	lexical  *index.BM25
+	embedder embed.Embedder
+	reranker *embed.CrossEncoder
+	conn     io.Closer
+	streamer *embed.StreamEmbedder
+	batcher  *embed.Batcher
+	cache    *cache.Cache
+	vectors  *cachedVectors // nil unless a quantized index re-scores
+
+	// what has been indexed, for the manifest
+	model   string
+	version string
+	mu      sync.Mutex
+	paths   []string
+	files   map[string]*FileInfo
+	// absolute paths of the files in files, which are not indexed again
+	indexed map[string]bool
+}
+
+// NewIndexer returns an empty Indexer. In lexical mode chunks are not
+// embedded, so unless a cross-encoder is configured the Indexer needs no
+// inference server, and its index can only be searched lexically.
+func NewIndexer(opts Options) (*Indexer, error) {
+	opts.setDefaults()
+	config := opts.Config
+
+	if err := checkMode(config.Mode); err != nil {
+		return nil, err
+	}
+	ix := &Indexer{
+		config:  config,
+		logf:    opts.Logf,
+		files:   make(map[string]*FileInfo),
+		indexed: make(map[string]bool),
+	}
+	var err error
+	if embedsChunks(config) {
+		ix.model, ix.version, err = embed.ModelID(config)
+		if err != nil {
+			return nil, err
+		}
+		ix.idx, err = index.New(config)
+		if err != nil {
+			return nil, err
+		}
+		ix.lexical = index.NewBM25(ix.idx)
+	} else {
+		ix.chunks = index.NewChunkList()
+		ix.lexical = index.NewBM25(ix.chunks)
+	}
+	if !needsEmbedder(config) {
+		return ix, nil
+	}
+
+	ix.embedder, ix.conn, err = newEmbedder(config, opts.Logf)
+	if err != nil {
+		return nil, err
+	}
+	ix.reranker, err = newCrossEncoder(config, ix.embedder)
+	if err != nil {
+		ix.Close()
+		return nil, err
+	}
+	if !embedsChunks(config) {
+		return ix, nil
+	}
+
+	if triton, ok := ix.embedder.(*embed.TritonEmbedder); ok && config.Stream {
+		ix.streamer = embed.NewStreamEmbedder(triton, config.MaxInFlight)
+		ix.batcher = embed.NewBatcher(ix.streamer, config.BatchSize, config.BatchTimeout, config.MaxInFlight)
+	} else {
+		ix.batcher = embed.NewBatcher(ix.embedder, config.BatchSize, config.BatchTimeout, config.MaxInFlight)
+	}
+
+	if !config.NoCache {
+		ix.cache, err = cache.NewCache(config.CacheDir, ix.model, ix.version, tokenize.Fingerprint)
+		if err != nil {
+			ix.Close()
+			return nil, fmt.Errorf("opening cache %s: %w", config.CacheDir, err)
+		}
+		if q, ok := ix.idx.(*index.Quantized); ok && config.Rerank > 0 {
+			ix.vectors = &cachedVectors{cache: ix.cache}
+			q.SetVectors(ix.vectors)
+		}
+	}
+	return ix, nil
+}
+
+// cachedVectors looks up the embeddings of indexed chunks in the cache, so
+// that a quantized index can re-score its candidates without keeping them.
+type cachedVectors struct {
+	mu    sync.RWMutex
+	cache *cache.Cache
+	keys  []string // the cache key of each record, empty if unknown
+}
+
+// add records key as that of the next record.
+func (v *cachedVectors) add(key string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.keys = append(v.keys, key)
+}
+
+func (v *cachedVectors) setCache(c *cache.Cache) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.cache = c
+}
+
+func (v *cachedVectors) Vector(id int) ([]float32, bool) {
+	v.mu.RLock()
+	if id >= len(v.keys) || v.keys[id] == "" {
+		v.mu.RUnlock()
+		return nil, false
+	}
+	c, key := v.cache, v.keys[id]
+	v.mu.RUnlock()
+	return c.Get(key)
+}
+
+// Close releases the connections held by the Indexer.
+func (ix *Indexer) Close() error {
+	var err error
+	if ix.streamer != nil {
+		err = ix.streamer.Close()
+	}
+	if ix.conn != nil {
+		if cerr := ix.conn.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// Check verifies that the embedder is usable, for the Triton backend that
+// the inference server is up and serves a model that matches the
+// tokenizer, and that the cross-encoder if any is loaded. See
+// embed.TritonEmbedder.Check.
+func (ix *Indexer) Check(ctx context.Context) error {
+	if err := ix.Searcher().Check(ctx); err != nil {
+		return err
+	}
+	return ix.resolveVersion(ctx)
+}
+
+// Searcher returns a Searcher over everything indexed so far, and anything
+// indexed later.
+func (ix *Indexer) Searcher() *Searcher {
+	return &Searcher{
+		config:   ix.config,
+		idx:      ix.idx,
+		lexical:  ix.lexical,
+		embedder: ix.embedder,
+		reranker: ix.reranker,
+	}
+}
+
+type chunkSource struct {
+	reader io.Reader // reader to read from
+	name   string    // filename or - for STDIN
+}
+
+// chunkFile sends every chunk of entry to chunkCh. Errors, including panics
+// from the parsers, only concern this file and are returned to the caller.
+func chunkFile(ctx context.Context, config *config.Config, entry chunkSource, chunkCh chan<- *chunk.Chunk) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	if closer, ok := entry.reader.(io.Closer); ok {
+		defer closer.Close()
+	}
+
+	chunker, err := chunk.NewChunker(entry.name, entry.reader, config, tokenize.TokenEnds)
+	if err != nil {
+		return err
+	}
+
+	var c *chunk.Chunk
+	for c, err = chunker.Next(); err == nil; c, err = chunker.Next() {
+		select {
+		case chunkCh <- c:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	if err != io.EOF {
+		return err
+	}
+	return nil
+}
+
+// resolveVersion pins the model version of a Triton embedder configured
+// without one to the latest version the server has, so that the cache and
+// the manifest record the version the embeddings actually come from.
+func (ix *Indexer) resolveVersion(ctx context.Context) error {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	triton, ok := ix.embedder.(*embed.TritonEmbedder)
+	if ix.version != "" || !ok {
+		return nil
+	}
+	version, err := triton.ResolveVersion(ctx)
+	if ctx.Err() != nil {
+		return ctx.Err()
+	} else if err != nil {
+		return err
+	}
+	ix.version = version
+	if ix.cache != nil {
+		ix.cache, err = cache.NewCache(ix.config.CacheDir, ix.model, version, tokenize.Fingerprint)
+		if err != nil {
+			return fmt.Errorf("opening cache %s: %w", ix.config.CacheDir, err)
+		}
+		if ix.vectors != nil {
+			ix.vectors.setCache(ix.cache)
+		}
+	}
+	return nil
+}
+
+// Index adds the files under paths to the index. Directories are walked
+// recursively and a path of - reads from standard input. Problems with
+// individual files are logged and skipped; any other error stops every
+// stage of the pipeline and is returned. Files that are already indexed are
+// skipped, so indexing overlapping paths does not add their chunks twice.
+func (ix *Indexer) Index(ctx context.Context, paths []string) error {
+	config := ix.config
+	if err := ix.resolveVersion(ctx); err != nil {
+		return err
+	}
+
+	// Every stage closes its output once all of its workers have returned,
+	// which happens when its input is closed and drained or ctx is
+	// cancelled. The walk closes parseCh, so the end of the walk ripples
+	// through to the indexer.
+	g, ctx := errgroup.WithContext(ctx)
+
+	parseCh := make(chan chunkSource, NUM_WORKERS)
+	ix.mu.Lock()
+	ix.paths = append(ix.paths, paths...)
+	ix.mu.Unlock()
+
+	emitter := func(osPathname string, file *os.File) error {
+		abs, err := filepath.Abs(osPathname)
+		if err != nil {
+			abs = osPathname
+		}
+		ix.mu.Lock()
+		if ix.indexed[abs] {
+			ix.mu.Unlock()
+			file.Close()
+			return nil
+		}
+		ix.indexed[abs] = true
+		if info, err := file.Stat(); err == nil {
+			ix.files[osPathname] = &FileInfo{Path: osPathname, Size: info.Size(), ModTime: info.ModTime()}
+		}
+		ix.mu.Unlock()
+		select {
+		case parseCh <- chunkSource{name: osPathname, reader: file}:
+			return nil
+		case <-ctx.Done():
+			file.Close()
+			return ctx.Err()
+		}
+	}
+	w := walker.NewWalker(emitter)
+	w.Ignore(config.Ignore)
+
+	g.Go(func() error {
+		defer close(parseCh)
+		useStdin := false
+		for _, path := range paths {
+			if path == "-" && !useStdin {
+				stdinInfo, _ := os.Stdin.Stat()
+				if (stdinInfo.Mode() & os.ModeCharDevice) != 0 {
+					return errors.New("pipe not found")
+				}
+				select {
+				case parseCh <- chunkSource{reader: os.Stdin, name: "-"}:
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+				useStdin = true
+			} else if err := w.Walk(path); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	chunkCh := make(chan *chunk.Chunk)
+	stage(g, NUM_WORKERS, func(i int) error {
+		for {
+			select {
+			case entry, ok := <-parseCh:
+				if !ok {
+					return nil
+				}
+				err := chunkFile(ctx, config, entry, chunkCh)
+				if errors.Is(err, chunk.BinaryFileError) {
+					ix.logf("Worker %d: skipping suspected binary file %s", i, entry.name)
+				} else if ctx.Err() != nil {
+					return ctx.Err()
+				} else if err != nil {
+					ix.logf("Error: Error parsing %s: %s", entry.name, err)
+				}
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}, func() { close(chunkCh) })
+
+	tokenCh := make(chan *tokenize.TokenizedChunk, 512)
+	stage(g, NUM_WORKERS, func(i int) error {
+		for {
+			select {
+			case c, ok := <-chunkCh:
+				if !ok {
+					return nil
+				}
+				t := tokenize.NewTokenizer(c)
+				for token := t.Next(); token != nil; token = t.Next() {
+					select {
+					case tokenCh <- token:
+					case <-ctx.Done():
+						return ctx.Err()
+					}
+				}
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}, func() { close(tokenCh) })
+
+	var embedCh chan *tokenize.TokenizedChunk
+	switch {
+	case ix.idx == nil:
+		// lexical search only needs the chunks
+		embedCh = tokenCh
+	case ix.cache == nil:
+		embedCh = make(chan *tokenize.TokenizedChunk, 512)
+		stage(g, 1, func(int) error {
+			return ix.batcher.Run(ctx, tokenCh, embedCh)
+		}, func() { close(embedCh) })
+	default:
+		embedCh = make(chan *tokenize.TokenizedChunk, 512)
+		c := ix.cache
+
+		// only chunks missing from the cache are sent to the batcher, so
+		// embedCh is fed both by the lookups and by the cache writer
+		var feeders sync.WaitGroup
+		feeders.Add(2)
+		go func() {
+			feeders.Wait()
+			close(embedCh)
+		}()
+
+		missCh := make(chan *tokenize.TokenizedChunk, 512)
+		stage(g, NUM_WORKERS, func(int) error {
+			for {
+				select {
+				case t, ok := <-tokenCh:
+					if !ok {
+						return nil
+					}
+					out := missCh
+					if v, ok := c.Get(c.Key(t.Text)); ok {
+						t.Embedding = v
+						out = embedCh
+					}
+					select {
+					case out <- t:
+					case <-ctx.Done():
+						return ctx.Err()
+					}
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			}
+		}, func() {
+			close(missCh)
+			feeders.Done()
+		})
+
+		batchCh := make(chan *tokenize.TokenizedChunk, 512)
+		stage(g, 1, func(int) error {
+			return ix.batcher.Run(ctx, missCh, batchCh)
+		}, func() { close(batchCh) })
+
+		stage(g, 1, func(int) error {
+			for {
+				select {
+				case t, ok := <-batchCh:
+					if !ok {
+						return nil
+					}
+					if err := c.Put(c.Key(t.Text), t.Embedding); err != nil {
+						ix.logf("Error: Error writing cache entry: %s", err)
+					}
+					select {
+					case embedCh <- t:
+					case <-ctx.Done():
+						return ctx.Err()
+					}
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			}
+		}, feeders.Done)
+	}
+
+	g.Go(func() error {
+		for t := range embedCh {
+			// this is the only goroutine adding chunks, so the last one
+			// added has the highest id
+			var id int
+			if ix.idx == nil {
+				id = ix.chunks.Add(*t.Chunk)
+			} else if err := ix.idx.Add(t.Embedding, *t.Chunk); err != nil {
+				ix.logf("Error: Error indexing chunk: %s", err)
+				continue
+			} else {
+				id = ix.idx.Len() - 1
+			}
+			ix.lexical.Add(id, t.Chunk.Content)
+			var key string
+			if ix.cache != nil {
+				key = ix.cache.Key(t.Text)
+			}
+			if ix.vectors != nil {
+				ix.vectors.add(key)
+			}
+			ix.mu.Lock()
+			if f, ok := ix.files[t.Chunk.Path]; ok {
+				f.Chunks++
+				if key != "" {
+					f.Keys = append(f.Keys, key)
+				}
+			}
+			ix.mu.Unlock()
+		}
+		return nil
+	})
+
+	return g.Wait()
+}
+
+// stage runs n workers in g and calls done once every worker has returned,
+// successfully or not. Stages use done to close their output channels.
+func stage(g *errgroup.Group, n int, worker func(i int) error, done func()) {
+	var wg sync.WaitGroup
+	wg.Add(n)
+	for i := 0; i < n; i++ {
+		i := i
+		g.Go(func() error {
+			defer wg.Done()
+			return worker(i)
+		})
+	}
+	g.Go(func() error {
+		wg.Wait()
+		done()
+		return nil
+	})
+}
+
+// Searcher answers queries against an index.
+type Searcher struct {
+	config   *config.Config
+	idx      index.Index
+	lexical  *index.BM25 // nil if the index was saved without one
+	embedder embed.Embedder
+	reranker *embed.CrossEncoder
+	conn     io.Closer // nil when the connections belong to an Indexer
+}
+
+func checkMode(mode string) error {
+	switch mode {
+	case SEMANTIC_MODE, LEXICAL_MODE, HYBRID_MODE:
+		return nil
+	default:
+		return fmt.Errorf("unknown search mode %q", mode)
+	}
+}
+
+// embedsChunks reports whether the search mode in config compares
+// embeddings. Lexical search only compares terms.
+func embedsChunks(config *config.Config) bool {
+	return config.Mode != LEXICAL_MODE
+}
+
+// needsEmbedder reports whether config needs an embedder, to embed chunks
+// and queries or to reach the cross-encoder on its inference server.
+func needsEmbedder(config *config.Config) bool {
+	return embedsChunks(config) || config.CrossEncoder != ""
+}
+
+// NewSearcher returns a Searcher over idx, which must have been built with
+// the model in opts.Config, and lexical, which may be nil for semantic
+// search. idx may be nil for lexical search, which without a cross-encoder
+// needs no embedder, so it does not connect to an inference server.
+func NewSearcher(opts Options, idx index.Index, lexical *index.BM25) (*Searcher, error) {
+	opts.setDefaults()
+	if err := checkMode(opts.Config.Mode); err != nil {
+		return nil, err
+	}
+	s := &Searcher{config: opts.Config, idx: idx, lexical: lexical}
+	if !needsEmbedder(opts.Config) {
+		return s, nil
+	}
+	var err error
+	s.embedder, s.conn, err = newEmbedder(opts.Config, opts.Logf)
+	if err != nil {
+		return nil, err
+	}
+	s.reranker, err = newCrossEncoder(opts.Config, s.embedder)
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// Close releases the connections held by a Searcher from NewSearcher or
+// OpenSearcher. Closing a Searcher from Indexer.Searcher does nothing.
+func (s *Searcher) Close() error {
+	if s.conn != nil {
+		return s.conn.Close()
+	}
+	return nil
+}
+
+// Check verifies that the embedder is usable, for the Triton backend that
+// the inference server is up and serves a model that matches the
+// tokenizer. See embed.TritonEmbedder.Check.
+func (s *Searcher) Check(ctx context.Context) error {
+	if s.embedder != nil && embedsChunks(s.config) {
+		if err := s.embedder.Check(ctx); err != nil {
+			return err
+		}
+	}
+	if s.reranker != nil {
+		return s.reranker.Check(ctx)
+	}
+	return nil
+}
+
+// Search returns the k chunks that best match query, best first. How
+// chunks are ranked depends on the configured mode: by similarity of
+// embeddings, by BM25 score, or by fusing both rankings. With a
+// cross-encoder, the best candidates are then re-ranked by its score.
+func (s *Searcher) Search(ctx context.Context, query string, k int) ([]Result, error) {
+	n := k
+	if s.reranker != nil {
+		n = maxInt(k, s.config.CrossEncoderTop)
+	}
+	results, err := s.rank(ctx, query, n)
+	if err != nil || s.reranker == nil {
+		return results, err
+	}
+	if err := s.rerank(ctx, query, results); err != nil {
+		return nil, err
+	}
+	if len(results) > k {
+		results = results[:k]
+	}
+	return results, nil
+}
+
+func (s *Searcher) rank(ctx context.Context, query string, k int) ([]Result, error) {
+	if s.config.Mode != SEMANTIC_MODE && s.lexical == nil {
+		return nil, fmt.Errorf("%s search needs a lexical index, rebuild the index with softgrep index", s.config.Mode)
+	}
+	switch s.config.Mode {
+	case LEXICAL_MODE:
+		return s.lexical.Search(query, k), nil
+	case HYBRID_MODE:
+		depth := maxInt(k, HYBRID_CANDIDATES)
+		semantic, err := s.semantic(ctx, query, depth)
+		if err != nil {
+			return nil, err
+		}
+		return index.Fuse(s.config.Fusion, s.config.LexicalWeight, semantic, s.lexical.Search(query, depth), k)
+	default:
+		return s.semantic(ctx, query, k)
+	}
+}
+
+// rerank replaces the score of each result with the cross-encoder's score
+// of it paired with query, and sorts results by it. Pairs are sent in
+// batches of at most config.BatchSize.
+func (s *Searcher) rerank(ctx context.Context, query string, results []Result) error {
+	pairs := make([]*tokenize.TokenizedChunk, len(results))
+	for i := range results {
+		pairs[i] = tokenize.EncodePair(query, &results[i].Chunk)
+	}
+	size := maxInt(s.config.BatchSize, 1)
+	for start := 0; start < len(pairs); start += size {
+		end := start + size
+		if end > len(pairs) {
+			end = len(pairs)
+		}
+		scores, err := s.reranker.Score(ctx, pairs[start:end])
+		if err != nil {
+			return fmt.Errorf("re-ranking results: %w", err)
+		}
+		for i, score := range scores {
+			results[start+i].Score = score
+		}
+	}
+	sort.SliceStable(results, func(i, j int) bool { return results[i].Score > results[j].Score })
+	return nil
+}
+
+func (s *Searcher) semantic(ctx context.Context, query string, k int) ([]Result, error) {
+	q := tokenize.NewTokenizer(&chunk.Chunk{Content: query}).Next()
+	if err := s.embedder.Embed(ctx, []*tokenize.TokenizedChunk{q}); err != nil {
+		return nil, fmt.Errorf("embedding query: %w", err)
+	}
+	return s.idx.Search(q.Embedding, k), nil
+}
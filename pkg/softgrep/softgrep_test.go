@@ -0,0 +1,503 @@
+package softgrep_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/skrider/softgrep/pkg/cache"
+	"github.com/skrider/softgrep/pkg/config"
+	"github.com/skrider/softgrep/pkg/embed"
+	"github.com/skrider/softgrep/pkg/embed/embedtest"
+	"github.com/skrider/softgrep/pkg/index"
+	"github.com/skrider/softgrep/pkg/softgrep"
+	"github.com/skrider/softgrep/pkg/tokenize"
+)
+
+const TESTDATA = "../../testdata/search"
+
+func TestIndexSearch(t *testing.T) {
+	s, err := embedtest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	cfg := embedtest.NewConfig(t, s)
+	ctx := context.Background()
+	// the second run is served from the cache
+	for run := 0; run < 2; run++ {
+		ix, err := softgrep.NewIndexer(softgrep.Options{Config: cfg})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ix.Index(ctx, []string{TESTDATA}); err != nil {
+			t.Fatal(err)
+		}
+		results, err := ix.Searcher().Search(ctx, "def fibonacci(n)", 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(results) != 3 {
+			t.Fatalf("run %d: expected 3 results, got %d", run, len(results))
+		}
+		if top := results[0]; filepath.Base(top.Path) != "sequences.py" || top.Start.Row != 0 {
+			t.Errorf("run %d: expected fibonacci in sequences.py, got %s:%d", run, top.Path, top.Start.Row+1)
+		}
+		ix.Close()
+	}
+}
+
+func TestIndexCancel(t *testing.T) {
+	s, err := embedtest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	ix, err := softgrep.NewIndexer(softgrep.Options{Config: embedtest.NewConfig(t, s)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ix.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := ix.Index(ctx, []string{TESTDATA}); err != context.Canceled {
+		t.Fatalf("expected %v, got %v", context.Canceled, err)
+	}
+}
+
+func TestIndexOverlapping(t *testing.T) {
+	s, err := embedtest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	cfg := embedtest.NewConfig(t, s)
+	cfg.Mode = softgrep.HYBRID_MODE
+	ctx := context.Background()
+	ix, err := softgrep.NewIndexer(softgrep.Options{Config: cfg})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ix.Close()
+
+	chunks := func() int {
+		dir := filepath.Join(t.TempDir(), ".softgrep")
+		if err := ix.Save(dir); err != nil {
+			t.Fatal(err)
+		}
+		m, err := softgrep.LoadManifest(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return m.Chunks()
+	}
+	if err := ix.Index(ctx, []string{TESTDATA}); err != nil {
+		t.Fatal(err)
+	}
+	want := chunks()
+
+	// the same files, once by the same path and once by an absolute one
+	abs, err := filepath.Abs(TESTDATA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ix.Index(ctx, []string{TESTDATA, abs}); err != nil {
+		t.Fatal(err)
+	}
+	if got := chunks(); got != want {
+		t.Errorf("expected %d chunks after indexing the same files again, got %d", want, got)
+	}
+
+	results, err := ix.Searcher().Search(ctx, "def fibonacci(n)", want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := make(map[string]bool)
+	for _, r := range results {
+		key := fmt.Sprintf("%s:%d-%d", filepath.Base(r.Path), r.StartByte, r.EndByte)
+		if seen[key] {
+			t.Errorf("duplicate result %s", key)
+		}
+		seen[key] = true
+	}
+}
+
+// copyTree copies the files in src, which must not have subdirectories, to
+// a new temporary directory.
+func copyTree(t *testing.T, src string) string {
+	dst := t.TempDir()
+	entries, err := os.ReadDir(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		b, err := os.ReadFile(filepath.Join(src, e.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dst, e.Name()), b, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dst
+}
+
+func TestSaveOpenGC(t *testing.T) {
+	s, err := embedtest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	cfg := embedtest.NewConfig(t, s)
+	ctx := context.Background()
+	src := copyTree(t, TESTDATA)
+	dir := filepath.Join(t.TempDir(), ".softgrep")
+
+	ix, err := softgrep.NewIndexer(softgrep.Options{Config: cfg})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ix.Close()
+	if err := ix.Index(ctx, []string{src}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ix.Save(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	searcher, m, err := softgrep.OpenSearcher(softgrep.Options{Config: cfg}, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Files) != 3 || m.Chunks() != 6 {
+		t.Fatalf("expected 6 chunks from 3 files, got %d from %d", m.Chunks(), len(m.Files))
+	}
+	// no version is configured, so the latest one the server has is recorded
+	if m.ModelVersion != "1" {
+		t.Errorf("expected model version 1 in the manifest, got %q", m.ModelVersion)
+	}
+	results, err := searcher.Search(ctx, "kubectl rollout restart deployment", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || filepath.Base(results[0].Path) != "deploy.sh" {
+		t.Fatalf("expected a result from deploy.sh, got %v", results)
+	}
+	searcher.Close()
+
+	for _, mode := range []string{softgrep.LEXICAL_MODE, softgrep.HYBRID_MODE} {
+		modeCfg := *cfg
+		modeCfg.Mode = mode
+		searcher, _, err := softgrep.OpenSearcher(softgrep.Options{Config: &modeCfg}, dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		results, err := searcher.Search(ctx, "IsPalindrome", 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(results) != 1 || filepath.Base(results[0].Path) != "strings.go" {
+			t.Errorf("%s: expected a result from strings.go, got %v", mode, results)
+		}
+		searcher.Close()
+	}
+
+	if stale, err := m.Stale(); err != nil || stale.Stale() {
+		t.Fatalf("expected a fresh index, got %+v, %v", stale, err)
+	}
+	if err := os.Remove(filepath.Join(src, "deploy.sh")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(src, "new.py"), []byte("def new():\n    pass\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	stale, err := m.Stale()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stale.Deleted) != 1 || len(stale.Added) != 1 || len(stale.Modified) != 0 {
+		t.Fatalf("expected one deleted and one added file, got %+v", stale)
+	}
+
+	// rebuilding drops deploy.sh from the index, so gc drops its entries
+	ix, err = softgrep.NewIndexer(softgrep.Options{Config: cfg})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ix.Close()
+	if err := ix.Index(ctx, []string{src}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ix.Save(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	// entries of another model in the same cache are not gc's to delete
+	staticCfg := *cfg
+	staticCfg.Backend = embed.BACKEND_STATIC
+	staticIx, err := softgrep.NewIndexer(softgrep.Options{Config: &staticCfg})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer staticIx.Close()
+	if err := staticIx.Index(ctx, []string{src}); err != nil {
+		t.Fatal(err)
+	}
+	static := countEntries(t, &staticCfg, "")
+	if static == 0 {
+		t.Fatal("expected the static backend to fill the cache")
+	}
+
+	deleted, err := softgrep.GC(softgrep.Options{Config: cfg})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted != 2 {
+		t.Errorf("expected gc to delete the 2 entries of deploy.sh, deleted %d", deleted)
+	}
+	if deleted, err := softgrep.GC(softgrep.Options{Config: cfg}); err != nil || deleted != 0 {
+		t.Errorf("expected a second gc to delete nothing, deleted %d, %v", deleted, err)
+	}
+	if n := countEntries(t, &staticCfg, ""); n != static {
+		t.Errorf("expected gc to keep the %d entries of the static model, %d left", static, n)
+	}
+}
+
+// countEntries returns the number of cache entries of the model in cfg, at
+// version if the model has no version of its own.
+func countEntries(t *testing.T, cfg *config.Config, version string) int {
+	model, v, err := embed.ModelID(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v == "" {
+		v = version
+	}
+	c, err := cache.NewCache(cfg.CacheDir, model, v, tokenize.Fingerprint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := 0
+	err = c.Keys(func(string) error {
+		n++
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestGCWithoutIndexes(t *testing.T) {
+	s, err := embedtest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	cfg := embedtest.NewConfig(t, s)
+	ix, err := softgrep.NewIndexer(softgrep.Options{Config: cfg})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ix.Close()
+	if err := ix.Index(context.Background(), []string{TESTDATA}); err != nil {
+		t.Fatal(err)
+	}
+	entries := countEntries(t, cfg, "1")
+	if entries == 0 {
+		t.Fatal("expected indexing to fill the cache")
+	}
+
+	// no index was saved, so nothing tells gc which entries are in use
+	deleted, err := softgrep.GC(softgrep.Options{Config: cfg})
+	if err != nil || deleted != 0 {
+		t.Errorf("expected gc without indexes to delete nothing, deleted %d, %v", deleted, err)
+	}
+	if n := countEntries(t, cfg, "1"); n != entries {
+		t.Errorf("expected %d cache entries after gc, got %d", entries, n)
+	}
+}
+
+func TestLexicalWithoutServer(t *testing.T) {
+	cfg := config.NewConfig()
+	// nothing listens here, and nothing should try to
+	cfg.Host, cfg.Port = "127.0.0.1", "1"
+	cfg.CacheDir = t.TempDir()
+	cfg.Mode = softgrep.LEXICAL_MODE
+	ctx := context.Background()
+	dir := filepath.Join(t.TempDir(), ".softgrep")
+
+	ix, err := softgrep.NewIndexer(softgrep.Options{Config: &cfg})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ix.Close()
+	if err := ix.Check(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := ix.Index(ctx, []string{TESTDATA}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ix.Save(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	searcher, m, err := softgrep.OpenSearcher(softgrep.Options{Config: &cfg}, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer searcher.Close()
+	if !m.LexicalOnly {
+		t.Error("expected the manifest to mark the index lexical only")
+	}
+	results, err := searcher.Search(ctx, "IsPalindrome", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || filepath.Base(results[0].Path) != "strings.go" {
+		t.Fatalf("expected a result from strings.go, got %v", results)
+	}
+
+	semanticCfg := cfg
+	semanticCfg.Mode = softgrep.SEMANTIC_MODE
+	if _, _, err := softgrep.OpenSearcher(softgrep.Options{Config: &semanticCfg}, dir); err == nil {
+		t.Error("expected semantic search of a lexical only index to fail")
+	}
+}
+
+func TestStaticBackend(t *testing.T) {
+	cfg := config.NewConfig()
+	cfg.Backend = "static"
+	cfg.NoCache = true
+	ctx := context.Background()
+
+	ix, err := softgrep.NewIndexer(softgrep.Options{Config: &cfg})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ix.Close()
+	if err := ix.Check(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := ix.Index(ctx, []string{TESTDATA}); err != nil {
+		t.Fatal(err)
+	}
+	results, err := ix.Searcher().Search(ctx, "def fibonacci(n)", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) == 0 || filepath.Base(results[0].Path) != "sequences.py" {
+		t.Errorf("expected fibonacci in sequences.py, got %+v", results)
+	}
+}
+
+func TestCrossEncoderRerank(t *testing.T) {
+	s, err := embedtest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	cfg := embedtest.NewConfig(t, s)
+	cfg.CrossEncoder = s.CrossEncoder
+	cfg.CrossEncoderTop = 5
+	cfg.BatchSize = 2
+	ctx := context.Background()
+
+	ix, err := softgrep.NewIndexer(softgrep.Options{Config: cfg})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ix.Close()
+	if err := ix.Check(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := ix.Index(ctx, []string{TESTDATA}); err != nil {
+		t.Fatal(err)
+	}
+	before := s.Infers()
+	results, err := ix.Searcher().Search(ctx, "def fibonacci(n)", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// one request for the query embedding and three batches of pairs
+	if n := s.Infers() - before; n != 4 {
+		t.Errorf("expected 4 inference requests, got %d", n)
+	}
+	if len(results) != 2 || filepath.Base(results[0].Path) != "sequences.py" || results[0].Score < results[1].Score {
+		t.Errorf("expected re-ranked results led by sequences.py, got %+v", results)
+	}
+
+	cfg.Backend = "static"
+	if _, err := softgrep.NewIndexer(softgrep.Options{Config: cfg}); err == nil {
+		t.Errorf("expected a cross-encoder to need the triton backend")
+	}
+}
+
+// TestQuantizedRescore checks that a quantized index re-scores its best
+// candidates with the embeddings in the cache, before and after it is
+// saved, so that they score as in a flat index.
+func TestQuantizedRescore(t *testing.T) {
+	src := t.TempDir()
+	for i := 0; i < index.MIN_TRAINING_VECTORS+100; i++ {
+		content := fmt.Sprintf("def scale_%d(x):\n    return x * %d + %d\n", i, i, 7*i)
+		if err := os.WriteFile(filepath.Join(src, fmt.Sprintf("f%d.py", i)), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cfg := config.NewConfig()
+	cfg.Backend = "static"
+	cfg.CacheDir = t.TempDir()
+	ctx := context.Background()
+
+	search := func(cfg *config.Config, open bool) []softgrep.Result {
+		ix, err := softgrep.NewIndexer(softgrep.Options{Config: cfg})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer ix.Close()
+		if err := ix.Index(ctx, []string{src}); err != nil {
+			t.Fatal(err)
+		}
+		searcher := ix.Searcher()
+		if open {
+			dir := filepath.Join(t.TempDir(), ".softgrep")
+			if err := ix.Save(dir); err != nil {
+				t.Fatal(err)
+			}
+			searcher, _, err = softgrep.OpenSearcher(softgrep.Options{Config: cfg}, dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer searcher.Close()
+		}
+		results, err := searcher.Search(ctx, "def scale_42(x)", 5)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return results
+	}
+
+	want := search(&cfg, false)
+	quantized := cfg
+	quantized.Quantize = index.SCALAR_QUANTIZATION
+	for _, open := range []bool{false, true} {
+		got := search(&quantized, open)
+		if len(got) != len(want) {
+			t.Fatalf("expected %d results, got %d", len(want), len(got))
+		}
+		for i := range want {
+			if got[i].Score != want[i].Score {
+				t.Errorf("saved %v: expected result %d to score %f, got %f", open, i, want[i].Score, got[i].Score)
+			}
+		}
+	}
+}
@@ -0,0 +1,456 @@
+package softgrep
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/skrider/softgrep/pkg/cache"
+	"github.com/skrider/softgrep/pkg/embed"
+	"github.com/skrider/softgrep/pkg/index"
+	"github.com/skrider/softgrep/pkg/tokenize"
+	"github.com/skrider/softgrep/pkg/walker"
+)
+
+// An index directory holds a persistent index and the manifest describing
+// what went into it.
+const INDEX_FILE = "index"
+const LEXICAL_FILE = "lexical"
+const CHUNKS_FILE = "chunks"
+const MANIFEST_FILE = "manifest.json"
+
+// REGISTRY_FILE lists, one per line, every index directory that has used a
+// cache directory, so that gc knows which entries are still referenced.
+const REGISTRY_FILE = "indexes"
+
+// FileInfo records the state of a file when it was indexed.
+type FileInfo struct {
+	Path    string    `json:"path"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+	Chunks  int       `json:"chunks"`
+	// cache keys of the file's chunks, empty when the cache was disabled
+	Keys []string `json:"keys,omitempty"`
+}
+
+// Manifest describes a persistent index.
+type Manifest struct {
+	Model        string `json:"model"`
+	ModelVersion string `json:"model_version"`
+	Tokenizer    string `json:"tokenizer"`
+	IndexType    string `json:"index_type"`
+	Quantize     string `json:"quantize"`
+	// LexicalOnly is set for indexes built in lexical mode, which hold no
+	// embeddings.
+	LexicalOnly bool `json:"lexical_only,omitempty"`
+	// Root is the working directory that relative paths are resolved
+	// against.
+	Root    string     `json:"root"`
+	Paths   []string   `json:"paths"`
+	Ignore  []string   `json:"ignore"`
+	Created time.Time  `json:"created"`
+	Files   []FileInfo `json:"files"`
+}
+
+// Chunks returns the number of indexed chunks.
+func (m *Manifest) Chunks() int {
+	n := 0
+	for _, f := range m.Files {
+		n += f.Chunks
+	}
+	return n
+}
+
+// recordKeys returns the cache key of each of records, empty for those
+// whose key is unknown. The keys of each file are listed in the order its
+// chunks were added, so the records of a file take them in turn.
+func (m *Manifest) recordKeys(records index.Records) []string {
+	files := make(map[string][]string, len(m.Files))
+	for _, f := range m.Files {
+		files[f.Path] = f.Keys
+	}
+	keys := make([]string, records.Len())
+	for id := range keys {
+		path := records.Record(id).Path
+		if fileKeys := files[path]; len(fileKeys) > 0 {
+			keys[id] = fileKeys[0]
+			files[path] = fileKeys[1:]
+		}
+	}
+	return keys
+}
+
+func (m *Manifest) resolve(path string) string {
+	if filepath.IsAbs(path) {
+		return filepath.Clean(path)
+	}
+	return filepath.Join(m.Root, path)
+}
+
+// Staleness lists the files whose contents may have changed since they were
+// indexed.
+type Staleness struct {
+	Modified []string
+	Deleted  []string
+	Added    []string
+}
+
+func (s *Staleness) Stale() bool {
+	return len(s.Modified)+len(s.Deleted)+len(s.Added) > 0
+}
+
+// Stale compares the manifest against the files currently under its paths.
+// A file counts as modified when its size or modification time changed.
+func (m *Manifest) Stale() (*Staleness, error) {
+	s := &Staleness{}
+	indexed := make(map[string]bool, len(m.Files))
+	for _, f := range m.Files {
+		path := m.resolve(f.Path)
+		indexed[path] = true
+		info, err := os.Stat(path)
+		if os.IsNotExist(err) {
+			s.Deleted = append(s.Deleted, f.Path)
+		} else if err != nil {
+			return nil, err
+		} else if info.Size() != f.Size || !info.ModTime().Equal(f.ModTime) {
+			s.Modified = append(s.Modified, f.Path)
+		}
+	}
+
+	w := walker.NewWalker(func(osPathname string, file *os.File) error {
+		file.Close()
+		if !indexed[osPathname] {
+			path := osPathname
+			if rel, err := filepath.Rel(m.Root, osPathname); err == nil && !strings.HasPrefix(rel, "..") {
+				path = rel
+			}
+			s.Added = append(s.Added, path)
+		}
+		return nil
+	})
+	w.Ignore(m.Ignore)
+	for _, path := range m.Paths {
+		if path == "-" {
+			continue
+		}
+		if err := w.Walk(m.resolve(path)); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// LoadManifest reads the manifest of the index in dir.
+func LoadManifest(dir string) (*Manifest, error) {
+	b, err := os.ReadFile(filepath.Join(dir, MANIFEST_FILE))
+	if err != nil {
+		return nil, err
+	}
+	m := &Manifest{}
+	if err := json.Unmarshal(b, m); err != nil {
+		return nil, fmt.Errorf("reading %s: %w", filepath.Join(dir, MANIFEST_FILE), err)
+	}
+	return m, nil
+}
+
+// writeFile replaces the file at path with whatever write produces. The
+// contents are written to a temporary file and renamed into place so
+// readers never observe a partial write.
+func writeFile(path string, write func(w io.Writer) error) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-")
+	if err != nil {
+		return err
+	}
+	buf := bufio.NewWriter(tmp)
+	if err := write(buf); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := buf.Flush(); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// readFile calls read with the contents of path.
+func readFile(path string, read func(r io.Reader) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return read(bufio.NewReader(f))
+}
+
+// Save writes the index and its manifest to dir, replacing any index
+// already there.
+func (ix *Indexer) Save(dir string) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	root, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+
+	ix.mu.Lock()
+	m := &Manifest{
+		Model:        ix.model,
+		ModelVersion: ix.version,
+		Tokenizer:    tokenize.Fingerprint,
+		IndexType:    ix.config.IndexType,
+		Quantize:     ix.config.Quantize,
+		LexicalOnly:  ix.idx == nil,
+		Root:         root,
+		Paths:        ix.paths,
+		Ignore:       ix.config.Ignore,
+		Created:      time.Now(),
+	}
+	for _, f := range ix.files {
+		m.Files = append(m.Files, *f)
+	}
+	ix.mu.Unlock()
+	sort.Slice(m.Files, func(i, j int) bool { return m.Files[i].Path < m.Files[j].Path })
+
+	if ix.idx != nil {
+		err = writeFile(filepath.Join(dir, INDEX_FILE), func(w io.Writer) error {
+			return index.Save(w, ix.idx)
+		})
+	} else {
+		err = writeFile(filepath.Join(dir, CHUNKS_FILE), func(w io.Writer) error {
+			return index.SaveChunkList(w, ix.chunks)
+		})
+	}
+	if err != nil {
+		return fmt.Errorf("writing index: %w", err)
+	}
+	err = writeFile(filepath.Join(dir, LEXICAL_FILE), func(w io.Writer) error {
+		return index.SaveBM25(w, ix.lexical)
+	})
+	if err != nil {
+		return fmt.Errorf("writing lexical index: %w", err)
+	}
+	err = writeFile(filepath.Join(dir, MANIFEST_FILE), func(w io.Writer) error {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(m)
+	})
+	if err != nil {
+		return fmt.Errorf("writing manifest: %w", err)
+	}
+
+	if ix.cache != nil {
+		return register(ix.config.CacheDir, dir)
+	}
+	return nil
+}
+
+// OpenSearcher returns a Searcher over the index saved in dir, along with
+// its manifest. Unless opts.Config selects lexical search, which only
+// compares terms, the index must have been built with the model and
+// tokenizer in opts.Config.
+func OpenSearcher(opts Options, dir string) (*Searcher, *Manifest, error) {
+	opts.setDefaults()
+	m, err := LoadManifest(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	if m.LexicalOnly && embedsChunks(opts.Config) {
+		return nil, nil, fmt.Errorf("index in %s was built for lexical search only, rebuild it with softgrep index --mode %s",
+			dir, opts.Config.Mode)
+	}
+	if embedsChunks(opts.Config) {
+		// Without a configured version, queries are embedded with the
+		// version the index was built with rather than whatever the
+		// server serves now.
+		if opts.Config.Backend == embed.BACKEND_TRITON && opts.Config.ModelVersion == "" {
+			config := *opts.Config
+			config.ModelVersion = m.ModelVersion
+			opts.Config = &config
+		}
+		model, version, err := embed.ModelID(opts.Config)
+		if err != nil {
+			return nil, nil, err
+		}
+		if m.Model != model || m.ModelVersion != version || m.Tokenizer != tokenize.Fingerprint {
+			return nil, nil, fmt.Errorf("index in %s was built with model %s version %q and tokenizer %s, rebuild it with softgrep index",
+				dir, m.Model, m.ModelVersion, m.Tokenizer)
+		}
+	}
+
+	var idx index.Index
+	var records index.Records
+	if m.LexicalOnly {
+		err = readFile(filepath.Join(dir, CHUNKS_FILE), func(r io.Reader) (err error) {
+			records, err = index.LoadChunkList(r)
+			return err
+		})
+	} else {
+		err = readFile(filepath.Join(dir, INDEX_FILE), func(r io.Reader) (err error) {
+			idx, err = index.Load(r)
+			records = idx
+			return err
+		})
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("reading index: %w", err)
+	}
+	// a quantized index re-scores its candidates with the embeddings in
+	// the cache rather than keeping them
+	if q, ok := idx.(*index.Quantized); ok && !opts.Config.NoCache {
+		c, err := cache.NewCache(opts.Config.CacheDir, m.Model, m.ModelVersion, m.Tokenizer)
+		if err != nil {
+			return nil, nil, fmt.Errorf("opening cache %s: %w", opts.Config.CacheDir, err)
+		}
+		q.SetVectors(&cachedVectors{cache: c, keys: m.recordKeys(idx)})
+	}
+
+	var lexical *index.BM25
+	if opts.Config.Mode != SEMANTIC_MODE {
+		err = readFile(filepath.Join(dir, LEXICAL_FILE), func(r io.Reader) (err error) {
+			lexical, err = index.LoadBM25(r, records)
+			return err
+		})
+		// an index saved before lexical search was added has none, which
+		// Searcher.rank reports
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, nil, fmt.Errorf("reading lexical index: %w", err)
+		}
+	}
+
+	s, err := NewSearcher(opts, idx, lexical)
+	if err != nil {
+		return nil, nil, err
+	}
+	return s, m, nil
+}
+
+func readRegistry(cacheDir string) ([]string, error) {
+	b, err := os.ReadFile(filepath.Join(cacheDir, REGISTRY_FILE))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return strings.Fields(string(b)), nil
+}
+
+func writeRegistry(cacheDir string, dirs []string) error {
+	return writeFile(filepath.Join(cacheDir, REGISTRY_FILE), func(w io.Writer) error {
+		for _, dir := range dirs {
+			if _, err := fmt.Fprintln(w, dir); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// register adds the index directory dir to the registry of cacheDir.
+func register(cacheDir string, dir string) error {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	dirs, err := readRegistry(cacheDir)
+	if err != nil {
+		return err
+	}
+	for _, d := range dirs {
+		if d == dir {
+			return nil
+		}
+	}
+	return writeRegistry(cacheDir, append(dirs, dir))
+}
+
+// GC deletes every entry of the cache in opts.Config.CacheDir that was
+// produced by the configured model and tokenizer but is not used by one of
+// the indexes saved with that cache, and returns the number of entries
+// deleted. Entries of other models are left alone. Without a configured
+// model version, the entries of every version an index was built with are
+// collected. Indexes that no longer exist are forgotten, and while no index
+// is registered nothing is deleted. Entries written by an index that is
+// being built while GC runs may be deleted.
+func GC(opts Options) (int, error) {
+	opts.setDefaults()
+	config := opts.Config
+	model, version, err := embed.ModelID(config)
+	if err != nil {
+		return 0, err
+	}
+
+	dirs, err := readRegistry(config.CacheDir)
+	if err != nil {
+		return 0, err
+	}
+	live := make(map[string]bool)
+	versions := make(map[string]bool)
+	if version != "" {
+		versions[version] = true
+	}
+	var kept []string
+	for _, dir := range dirs {
+		m, err := LoadManifest(dir)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		} else if err != nil {
+			// without the manifest we cannot tell which entries are used
+			return 0, err
+		}
+		kept = append(kept, dir)
+		if m.Model == model && version == "" {
+			versions[m.ModelVersion] = true
+		}
+		for _, f := range m.Files {
+			for _, key := range f.Keys {
+				live[key] = true
+			}
+		}
+	}
+	if len(kept) != len(dirs) {
+		if err := writeRegistry(config.CacheDir, kept); err != nil {
+			return 0, err
+		}
+	}
+	if len(kept) == 0 {
+		// with no index to keep entries for, GC would empty the cache
+		return 0, nil
+	}
+
+	deleted := 0
+	for version := range versions {
+		c, err := cache.NewCache(config.CacheDir, model, version, tokenize.Fingerprint)
+		if err != nil {
+			return deleted, err
+		}
+		err = c.Keys(func(key string) error {
+			if live[key] {
+				return nil
+			}
+			if err := c.Delete(key); err != nil {
+				return err
+			}
+			deleted++
+			return nil
+		})
+		if err != nil {
+			return deleted, err
+		}
+	}
+	return deleted, nil
+}
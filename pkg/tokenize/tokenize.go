@@ -1,11 +1,15 @@
 package tokenize
 
 import (
+	"crypto/sha256"
 	_ "embed"
+	"encoding/hex"
 	"fmt"
 	"sync"
+	"unicode/utf8"
 
 	"github.com/daulet/tokenizers"
+	"github.com/skrider/softgrep/pkg/chunk"
 )
 
 //go:embed tokenizer.json
@@ -13,6 +17,9 @@ var vocab []byte
 
 var tokenizer *tokenizers.Tokenizer
 
+// Fingerprint identifies the vocabulary the tokenizer was built from.
+var Fingerprint string
+
 const MAX_LEN = 512
 
 // from https://github.com/microsoft/CodeBERT/blob/master/CodeBERT/codesearch/utils.py:
@@ -49,10 +56,26 @@ func init() {
 	}
 	tokenizer = t
 
-	specialTokensString := fmt.Sprintf("%s %s", CLS_TOKEN, SEP_TOKEN)
-	specialTokens, _ := tokenizer.Encode(specialTokensString, false)
-	CLS_TOKEN_ID = specialTokens[0]
-	SEP_TOKEN_ID = specialTokens[1]
+	CLS_TOKEN_ID = specialToken(CLS_TOKEN)
+	SEP_TOKEN_ID = specialToken(SEP_TOKEN)
+
+	// the special token ids are part of the encoding, so that embeddings of
+	// sequences framed by different tokens are not mixed up in caches
+	h := sha256.New()
+	h.Write(vocab)
+	fmt.Fprintf(h, "%d %d", CLS_TOKEN_ID, SEP_TOKEN_ID)
+	Fingerprint = hex.EncodeToString(h.Sum(nil)[:8])
+}
+
+// specialToken returns the id of a special token, which must encode to
+// exactly one token. Encoding several special tokens at once would also
+// yield the tokens of the whitespace between them.
+func specialToken(token string) uint32 {
+	ids, _ := tokenizer.Encode(token, false)
+	if len(ids) != 1 {
+		panic(fmt.Sprintf("tokenize: %s encodes to %d tokens", token, len(ids)))
+	}
+	return ids[0]
 }
 
 type Tokenizer interface {
@@ -64,6 +87,9 @@ type TokenizedChunk struct {
 	InputIds  []uint32
 	InputMask []uint32
 	Text      string
+	Embedding []float32
+	// region of the source file the tokens were taken from
+	Chunk *chunk.Chunk
 }
 
 func newTokenizedChunk() *TokenizedChunk {
@@ -79,14 +105,16 @@ func newTokenizedChunk() *TokenizedChunk {
 	return t
 }
 
-func (t *TokenizedChunk) addToken(token uint32) {
+// addToken appends token to the given segment, 0 for the first sequence and
+// 1 for the second sequence of a pair.
+func (t *TokenizedChunk) addToken(token uint32, segment uint32) {
 	lenth := len(t.Tokens)
 	if lenth == MAX_LEN-1 {
 		panic("TokenizedChunk is full")
 	}
 
 	t.Tokens = append(t.Tokens, token)
-	t.InputIds = append(t.InputIds, 0)
+	t.InputIds = append(t.InputIds, segment)
 	t.InputMask = append(t.InputMask, 1)
 }
 
@@ -94,10 +122,13 @@ func (t *TokenizedChunk) len() int {
 	return len(t.Tokens)
 }
 
-func (t *TokenizedChunk) finalize() {
+// finalize closes the last segment with a SEP token and pads the sequence.
+func (t *TokenizedChunk) finalize(c *chunk.Chunk, segment uint32) {
+	t.Chunk = c
+
 	// add SEP token
 	t.Tokens = append(t.Tokens, SEP_TOKEN_ID)
-	t.InputIds = append(t.InputIds, 0)
+	t.InputIds = append(t.InputIds, segment)
 	t.InputMask = append(t.InputMask, 1)
 
 	length := len(t.Tokens)
@@ -121,29 +152,97 @@ type BertTokenizer struct {
 	mu      sync.Mutex
 }
 
-func NewTokenizer(text string) Tokenizer {
+// tokenEnds returns the byte offset at which each token ends. The vocabulary
+// is byte-level BPE, so every rune of a token string stands for exactly one
+// byte of input.
+func tokenEnds(tokens []string) []int {
+	ends := make([]int, len(tokens))
+	end := 0
+	for i, t := range tokens {
+		end += utf8.RuneCountInString(t)
+		ends[i] = end
+	}
+	return ends
+}
+
+// VocabSize returns the number of tokens in the vocabulary, including the
+// special tokens.
+func VocabSize() int {
+	return int(tokenizer.VocabSize())
+}
+
+// TokenEnds returns the byte offset at which each token of text ends. It
+// satisfies chunk.TokenFunc.
+func TokenEnds(text string) []int {
+	_, tokens := tokenizer.Encode(text, false)
+	return tokenEnds(tokens)
+}
+
+func NewTokenizer(c *chunk.Chunk) Tokenizer {
 	// length of a chunk without special tokens
 	chunkLen := MAX_LEN - 2
-	indices, _ := tokenizer.Encode(text, false)
+	indices, tokens := tokenizer.Encode(c.Content, false)
 
 	// accumulate a token
 	chunks := make([]*TokenizedChunk, 0, MAX_LEN)
-	chunk := newTokenizedChunk()
-
-	for _, token := range indices {
-		if chunk.len() == chunkLen {
-			chunk.finalize()
-			chunks = append(chunks, chunk)
-			chunk = newTokenizedChunk()
+	current := newTokenizedChunk()
+
+	// byte range of c.Content covered by the current chunk
+	ends := tokenEnds(tokens)
+	start, end := 0, 0
+	for i, token := range indices {
+		if current.len() == chunkLen {
+			current.finalize(c.Slice(start, end), 0)
+			chunks = append(chunks, current)
+			current = newTokenizedChunk()
+			start = end
 		}
-		chunk.addToken(token)
+		current.addToken(token, 0)
+		end = ends[i]
 	}
-	chunk.finalize()
-	chunks = append(chunks, chunk)
+	current.finalize(c.Slice(start, end), 0)
+	chunks = append(chunks, current)
 
 	return &BertTokenizer{chunks: chunks}
 }
 
+// MAX_QUERY_LEN bounds the tokens of the query in a sequence pair, leaving
+// the rest of the sequence to the code.
+const MAX_QUERY_LEN = 64
+
+// EncodePair encodes query and the code in c as a sequence pair for a
+// cross-encoder, in the form
+//
+//	tokens:   <s> query </s> code </s> <pad>...
+//	type_ids:  0    0    0    1    1     0
+//
+// A long query and the end of long code are truncated. The Chunk of the
+// result covers the code that was kept.
+func EncodePair(query string, c *chunk.Chunk) *TokenizedChunk {
+	queryIds, _ := tokenizer.Encode(query, false)
+	if len(queryIds) > MAX_QUERY_LEN {
+		queryIds = queryIds[:MAX_QUERY_LEN]
+	}
+	codeIds, codeTokens := tokenizer.Encode(c.Content, false)
+	end := len(c.Content)
+	// room for <s> and two </s>
+	if codeLen := MAX_LEN - 3 - len(queryIds); len(codeIds) > codeLen {
+		codeIds = codeIds[:codeLen]
+		end = tokenEnds(codeTokens[:codeLen])[codeLen-1]
+	}
+
+	t := newTokenizedChunk()
+	for _, id := range queryIds {
+		t.addToken(id, 0)
+	}
+	t.addToken(SEP_TOKEN_ID, 0)
+	for _, id := range codeIds {
+		t.addToken(id, 1)
+	}
+	t.finalize(c.Slice(0, end), 1)
+	return t
+}
+
 func (t *BertTokenizer) Next() *TokenizedChunk {
 	t.mu.Lock()
 	defer t.mu.Unlock()
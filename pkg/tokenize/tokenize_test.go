@@ -0,0 +1,74 @@
+package tokenize
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/skrider/softgrep/pkg/chunk"
+)
+
+func TestSpecialTokens(t *testing.T) {
+	// the RoBERTa vocabulary CodeBERT is trained with
+	if CLS_TOKEN_ID != 0 {
+		t.Errorf("expected %s to have id 0, got %d", CLS_TOKEN, CLS_TOKEN_ID)
+	}
+	if SEP_TOKEN_ID != 2 {
+		t.Errorf("expected %s to have id 2, got %d", SEP_TOKEN, SEP_TOKEN_ID)
+	}
+}
+
+func TestEncodePair(t *testing.T) {
+	code := &chunk.Chunk{Path: "a.go", Content: strings.Repeat("x := y + z\n", 400)}
+	cases := []struct {
+		name     string
+		query    string
+		queryLen int
+	}{
+		{"query", "add two numbers", 3},
+		{"empty", "", 0},
+		// a long query is cut to leave room for the code
+		{"long", strings.Repeat("add two numbers ", 100), MAX_QUERY_LEN},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			pair := EncodePair(c.query, code)
+			if len(pair.Tokens) != MAX_LEN {
+				t.Fatalf("expected %d tokens, got %d", MAX_LEN, len(pair.Tokens))
+			}
+			if pair.Tokens[0] != CLS_TOKEN_ID {
+				t.Errorf("expected the pair to start with %s", CLS_TOKEN)
+			}
+
+			// <s> query </s> has type 0, code </s> has type 1
+			var seps []int
+			for i, id := range pair.Tokens {
+				if id == SEP_TOKEN_ID && pair.InputMask[i] == 1 {
+					seps = append(seps, i)
+				}
+			}
+			if len(seps) != 2 {
+				t.Fatalf("expected two %s tokens, got %d", SEP_TOKEN, len(seps))
+			}
+			if seps[0] != 1+c.queryLen {
+				t.Errorf("expected %d query tokens, got %d", c.queryLen, seps[0]-1)
+			}
+			for i := range pair.Tokens {
+				want := uint32(0)
+				if i > seps[0] && i <= seps[1] {
+					want = 1
+				}
+				if pair.InputIds[i] != want {
+					t.Fatalf("expected token %d to have type %d, got %d", i, want, pair.InputIds[i])
+				}
+			}
+
+			// the code does not fit, so the pair covers a prefix of it
+			if seps[1] != MAX_LEN-1 {
+				t.Errorf("expected truncated code to fill the sequence, got %s at %d", SEP_TOKEN, seps[1])
+			}
+			if pair.Chunk.StartByte != 0 || pair.Chunk.EndByte == 0 || int(pair.Chunk.EndByte) >= len(code.Content) {
+				t.Errorf("expected a proper prefix of the code, got bytes %d-%d of %d", pair.Chunk.StartByte, pair.Chunk.EndByte, len(code.Content))
+			}
+		})
+	}
+}
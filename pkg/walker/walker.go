@@ -1,9 +1,14 @@
 package walker
 
 import (
+	"errors"
 	"fmt"
+	"io/fs"
+	"log"
 	"os"
+	"path/filepath"
 	"regexp"
+	"strings"
 
 	ignore "github.com/denormal/go-gitignore"
 	"github.com/karrick/godirwalk"
@@ -17,12 +22,14 @@ type Walker struct {
 	seenPaths   map[string]bool
 	walkOptions *godirwalk.Options
 	emitter     EmitterFunc
+	patterns    []string
+	root        string
 }
 
 var SKIP_RE *regexp.Regexp
 
 func init() {
-	SKIP_RE, _ = regexp.Compile("(/.git|/node_modules|[^/].log|\\w.lock|.zip|.tgz)$")
+	SKIP_RE, _ = regexp.Compile("(/.git|/.softgrep|/node_modules|[^/].log|\\w.lock|.zip|.tgz)$")
 }
 
 func IsBinary(file *os.File) bool {
@@ -46,9 +53,13 @@ func (w *Walker) callback(osPathname string, directoryEntry *godirwalk.Dirent) e
 	if w.skipRe.MatchString(osPathname) {
 		return godirwalk.SkipThis
 	}
-	for _, ignoreFile := range w.ignoreFiles {
-		if (*ignoreFile).Ignore(osPathname) {
-			return godirwalk.SkipThis
+	// paths given explicitly are never ignored, which also keeps the
+	// ignore files from matching their own base directory
+	if filepath.Clean(osPathname) != w.root {
+		for _, ignoreFile := range w.ignoreFiles {
+			if (*ignoreFile).Ignore(osPathname) {
+				return godirwalk.SkipThis
+			}
 		}
 	}
 
@@ -83,9 +94,39 @@ func NewWalker(emitter EmitterFunc) *Walker {
 	}
 }
 
+// errorCallback skips files and directories that cannot be read. Errors
+// returned by the emitter stop the walk.
+func (w *Walker) errorCallback(osPathname string, err error) godirwalk.ErrorAction {
+	var pathErr *fs.PathError
+	if errors.As(err, &pathErr) {
+		log.Printf("Error: Error reading %s: %s", osPathname, err)
+		return godirwalk.SkipNode
+	}
+	return godirwalk.Halt
+}
+
+// Ignore skips files matching any of patterns, which are gitignore patterns
+// relative to each path walked.
+func (w *Walker) Ignore(patterns []string) {
+	w.patterns = append(w.patterns, patterns...)
+}
+
 func (w *Walker) Walk(path string) error {
+	w.root = filepath.Clean(path)
+	if len(w.patterns) > 0 {
+		base, err := filepath.Abs(path)
+		if err != nil {
+			return err
+		}
+		if info, err := os.Stat(base); err == nil && !info.IsDir() {
+			base = filepath.Dir(base)
+		}
+		patterns := ignore.New(strings.NewReader(strings.Join(w.patterns, "\n")), base, nil)
+		w.ignoreFiles = append(w.ignoreFiles, &patterns)
+	}
 	return godirwalk.Walk(path, &godirwalk.Options{
 		Callback:            w.callback,
+		ErrorCallback:       w.errorCallback,
 		FollowSymbolicLinks: true,
 	})
 }
@@ -0,0 +1,9 @@
+#!/bin/env bash
+
+upload_artifacts() {
+    aws s3 cp ./build "s3://$BUCKET/artifacts" --recursive
+}
+
+restart_service() {
+    kubectl rollout restart deployment/softgrep
+}
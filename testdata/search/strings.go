@@ -0,0 +1,19 @@
+package search
+
+import "strings"
+
+// Reverse returns s with its runes in reverse order.
+func Reverse(s string) string {
+	runes := []rune(s)
+	for i, j := 0, len(runes)-1; i < j; i, j = i+1, j-1 {
+		runes[i], runes[j] = runes[j], runes[i]
+	}
+	return string(runes)
+}
+
+// IsPalindrome reports whether s reads the same forwards and backwards,
+// ignoring case.
+func IsPalindrome(s string) bool {
+	s = strings.ToLower(s)
+	return s == Reverse(s)
+}